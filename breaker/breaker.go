@@ -0,0 +1,158 @@
+package breaker
+
+import (
+	// Go Internal Packages
+	"context"
+	"sync"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+
+	// External Packages
+	"go.uber.org/zap"
+)
+
+// ErrOpen is returned for calls rejected while the breaker is open
+var ErrOpen = errors.NewError("circuit breaker is open")
+
+// Probe reports whether the protected dependency is reachable again
+type Probe func(ctx context.Context) error
+
+// Breaker opens after a number of consecutive failures of the protected dependency, rejecting calls
+// until a periodic probe succeeds, at which point it closes again.
+type Breaker struct {
+	name          string
+	threshold     int
+	probeInterval time.Duration
+	probe         Probe
+	logger        *zap.Logger
+
+	mu        sync.Mutex
+	failures  int
+	recovered chan struct{} // non-nil while open, closed once the breaker closes
+	listeners []func(open bool)
+}
+
+// New creates a closed breaker which opens after threshold consecutive failures and
+// probes the dependency every probeInterval while open.
+func New(name string, threshold int, probeInterval time.Duration, probe Probe, logger *zap.Logger) *Breaker {
+	return &Breaker{
+		name:          name,
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		probe:         probe,
+		logger:        logger,
+	}
+}
+
+// OnStateChange registers a listener called whenever the breaker opens or closes
+func (b *Breaker) OnStateChange(fn func(open bool)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// Open reports whether the breaker is open
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.recovered != nil
+}
+
+// Allow returns ErrOpen while the breaker is open
+func (b *Breaker) Allow() error {
+	if b.Open() {
+		return ErrOpen
+	}
+	return nil
+}
+
+// Success resets the consecutive failures
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// Failure counts a failure of the dependency, opening the breaker once the threshold is reached
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	b.failures++
+	if b.recovered != nil || b.failures < b.threshold {
+		b.mu.Unlock()
+		return
+	}
+	b.recovered = make(chan struct{})
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	b.logger.Error("circuit breaker opened", zap.String("breaker", b.name), zap.Int("failures", b.threshold), zap.Error(err))
+	for _, fn := range listeners {
+		fn(true)
+	}
+}
+
+// Wait blocks until the breaker is closed or the context is done
+func (b *Breaker) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	recovered := b.recovered
+	b.mu.Unlock()
+	if recovered == nil {
+		return nil
+	}
+
+	select {
+	case <-recovered:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run probes the dependency every probe interval while the breaker is open, closing it once
+// a probe succeeds. Blocks until the context is canceled.
+func (b *Breaker) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !b.Open() {
+			continue
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, b.probeInterval)
+		err := b.probe(probeCtx)
+		cancel()
+		if err != nil {
+			b.logger.Warn("circuit breaker probe failed", zap.String("breaker", b.name), zap.Error(err))
+			continue
+		}
+		b.close()
+	}
+}
+
+// close closes the breaker, releasing the waiters and notifying the listeners
+func (b *Breaker) close() {
+	b.mu.Lock()
+	if b.recovered == nil {
+		b.mu.Unlock()
+		return
+	}
+	close(b.recovered)
+	b.recovered = nil
+	b.failures = 0
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	b.logger.Info("circuit breaker closed", zap.String("breaker", b.name))
+	for _, fn := range listeners {
+		fn(false)
+	}
+}
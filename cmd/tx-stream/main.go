@@ -7,15 +7,21 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	// Local Packages
+	breaker "tx-stream/breaker"
 	config "tx-stream/config"
+	health "tx-stream/health"
 	kafka "tx-stream/kafka"
+	metrics "tx-stream/metrics"
 	models "tx-stream/models"
 	mongodb "tx-stream/repositories/mongodb"
 	redis "tx-stream/repositories/redis"
+	server "tx-stream/server"
+	deserializers "tx-stream/services/deserializers"
+	pii "tx-stream/services/pii"
 	txpsr "tx-stream/services/processors"
+	validators "tx-stream/services/validators"
 
 	// External Packages
 	"github.com/alecthomas/kingpin/v2"
@@ -24,28 +30,48 @@ import (
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/twmb/franz-go/plugin/kprom"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// LoadConfig loads the default configuration and overrides it with the config file
-// specified by the path defined in the config flag
-func LoadConfig() *koanf.Koanf {
-	configPathMsg := "path to the application config file"
-	configPath := kingpin.Flag("config", configPathMsg).Short('c').Default("config.yml").String()
+var (
+	configPath = kingpin.Flag("config", "path to the application config file").Short('c').Default("config.yml").String()
 
-	kingpin.Parse()
+	runCmd = kingpin.Command("run", "consume transactions from kafka").Default()
+	dlqCmd = kingpin.Command("dlq", "manage the dead-letter queue")
+)
+
+// LoadConfig loads the default configuration and overrides it with the config file at the given path
+func LoadConfig(configPath string) *koanf.Koanf {
 	k := koanf.New(".")
 	_ = k.Load(rawbytes.Provider(config.DefaultConfig), yaml.Parser())
-	if *configPath != "" {
-		_ = k.Load(file.Provider(*configPath), yaml.Parser())
+	if configPath != "" {
+		_ = k.Load(file.Provider(configPath), yaml.Parser())
 	}
 	return k
 }
 
+// NewLogger builds the application logger
+func NewLogger(appKonf config.Config) *zap.Logger {
+	cfg := zap.NewProductionConfig()
+	cfg.Encoding = "logfmt"
+	_ = cfg.Level.UnmarshalText([]byte(appKonf.Logger.Level))
+	cfg.InitialFields = make(map[string]any)
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.InitialFields["host"], _ = os.Hostname()
+	cfg.InitialFields["service"] = appKonf.Application
+	cfg.OutputPaths = []string{"stdout"}
+	logger, _ := cfg.Build()
+	return logger
+}
+
 func main() {
-	k := LoadConfig()
+	command := kingpin.Parse()
+	k := LoadConfig(*configPath)
 	appKonf := config.Config{}
 
 	// Unmarshalling config into struct
@@ -63,28 +89,42 @@ func main() {
 		k.Print()
 	}
 
-	if !appKonf.Kafka.Consume {
-		log.Fatalf("kafka consumer is not enabled")
+	logger := NewLogger(appKonf)
+
+	var exitCode int
+	switch command {
+	case replayCmd.FullCommand():
+		exitCode = replay(appKonf, logger)
+	case migrateCmd.FullCommand():
+		exitCode = migrate(appKonf, logger)
+	default:
+		exitCode = run(appKonf, logger)
 	}
 
-	cfg := zap.NewProductionConfig()
-	cfg.Encoding = "logfmt"
-	_ = cfg.Level.UnmarshalText([]byte(k.String("logger.level")))
-	cfg.InitialFields = make(map[string]any)
-	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	cfg.InitialFields["host"], _ = os.Hostname()
-	cfg.InitialFields["service"] = appKonf.Application
-	cfg.OutputPaths = []string{"stdout"}
-	logger, _ := cfg.Build()
-	defer func() {
-		_ = logger.Sync()
-	}()
+	_ = logger.Sync()
+	os.Exit(exitCode)
+}
+
+// run starts the consumer and blocks until it is shut down, returning the process exit code
+func run(appKonf config.Config, logger *zap.Logger) int {
+	if !appKonf.Kafka.Consume {
+		logger.Fatal("kafka consumer is not enabled")
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Kafka client and application metrics share a registry, served on /metrics
+	registry := prometheus.NewRegistry()
+	kafkaMetrics := kprom.NewMetrics("transactions", kprom.Registry(registry), kprom.GoCollectors())
+	appMetrics := metrics.New("tx_stream", registry)
+
+	httpServer := server.NewServer(appKonf.HTTP.Address, logger)
+	httpServer.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	httpServer.Start()
+
 	// Mongo Connection
-	mongoClient, err := mongodb.Connect(ctx, appKonf.Mongo.URI)
+	mongoClient, err := mongodb.Connect(ctx, appKonf.Mongo.URI, NewMongoOptions(appKonf), appMetrics.MongoMonitor())
 	if err != nil {
 		logger.Fatal("cannot create mongo client", zap.Error(err))
 	}
@@ -94,37 +134,236 @@ func main() {
 	if err != nil {
 		logger.Fatal("cannot create redis client", zap.Error(err))
 	}
+	redisClient.AddHook(appMetrics.RedisHook())
 
-	txRepo := mongodb.NewTxRepository(mongoClient)
-	dlQueue := redis.NewDeadLetterQueue(redisClient, logger)
-	txProcessor := txpsr.NewTxProcessor(logger, txRepo)
+	txRepo := NewTxRepository(appKonf, logger, mongoClient)
+	if appKonf.Mongo.Schema.Bootstrap {
+		if _, err = ensureSchema(ctx, appKonf, txRepo, true, logger); err != nil {
+			logger.Fatal("cannot bootstrap mongo schema", zap.Error(err))
+		}
+	}
+
+	var dlQueue kafka.DeadLetterQueue
+	switch appKonf.DLQ.Backend {
+	case "kafka":
+		dlqProducer, err := kafka.NewDeadLetterProducer(appKonf.Kafka.Brokers, appKonf.DLQ.Kafka.Topic, logger)
+		if err != nil {
+			logger.Fatal("cannot create dlq producer", zap.Error(err))
+		}
+		defer dlqProducer.Close()
+		dlQueue = dlqProducer
+	default:
+		dlq := appKonf.Redis.DLQ
+		redisDLQ := redis.NewDeadLetterQueue(redisClient, dlq.Stream, dlq.Group, dlq.MaxLen, logger)
+		if err = redisDLQ.EnsureGroup(ctx); err != nil {
+			logger.Fatal("cannot create dlq consumer group", zap.Error(err))
+		}
+		dlQueue = redisDLQ
+	}
+
+	// Writes go through the circuit breaker, which pauses consumption while mongo is unavailable
+	var storeBreaker kafka.CircuitBreaker
+	var processorRepo txpsr.TxRepository = txRepo
+	if brk := appKonf.Mongo.Breaker; brk.FailureThreshold > 0 {
+		mongoBreaker := breaker.New("mongo", brk.FailureThreshold, brk.ProbeInterval,
+			func(ctx context.Context) error { return mongoClient.Ping(ctx, nil) }, logger)
+		mongoBreaker.OnStateChange(func(open bool) {
+			state := 0.0
+			if open {
+				state = 1
+			}
+			appMetrics.BreakerOpen.WithLabelValues("mongo").Set(state)
+		})
+		go mongoBreaker.Run(ctx)
+		storeBreaker = mongoBreaker
+		processorRepo = mongodb.NewBreakerTxRepository(txRepo, mongoBreaker)
+	}
+	txProcessor := NewTxProcessor(appKonf, logger, processorRepo)
 
-	metrics := kprom.NewMetrics("transactions")
 	conf := &models.ConsumerConfig{
 		Brokers:               appKonf.Kafka.Brokers,
 		Name:                  appKonf.Kafka.ConsumerName,
 		Topic:                 appKonf.Kafka.Topic,
 		EachPartitionChanSize: appKonf.Kafka.ChannelSize,
+		QueueHighWatermark:    appKonf.Kafka.Backpressure.HighWatermark,
+		QueueLowWatermark:     appKonf.Kafka.Backpressure.LowWatermark,
 		RecordsPerPoll:        appKonf.Kafka.RecordsPerPoll,
+		ExactlyOnce:           appKonf.Kafka.ExactlyOnce,
+		Batch: models.BatchPolicy{
+			MaxRecords: appKonf.Kafka.Batch.MaxRecords,
+			MaxBytes:   appKonf.Kafka.Batch.MaxBytes,
+			Linger:     appKonf.Kafka.Batch.Linger,
+		},
+		Parallel: models.ParallelPolicy{
+			Concurrency: appKonf.Kafka.Parallel.Concurrency,
+			KeyBy:       models.KeyBy(appKonf.Kafka.Parallel.KeyBy),
+		},
+		Retry: models.RetryPolicy{
+			MaxAttempts: appKonf.Kafka.Retry.MaxAttempts,
+			BaseBackoff: appKonf.Kafka.Retry.BaseBackoff,
+			MaxBackoff:  appKonf.Kafka.Retry.MaxBackoff,
+			Jitter:      models.Jitter(appKonf.Kafka.Retry.Jitter),
+			Deadline:    appKonf.Kafka.Retry.Deadline,
+		},
 	}
 
-	txConsumer, err := kafka.NewTxConsumer(conf, logger, txProcessor, dlQueue, metrics)
+	txConsumer, err := kafka.NewTxConsumer(conf, logger, txProcessor, dlQueue, txRepo, storeBreaker, kafkaMetrics, appMetrics)
 	if err != nil {
 		logger.Fatal("cannot create consumer", zap.Error(err))
 	}
 
-	go func() {
-		if err = txConsumer.Poll(ctx); err != nil {
-			logger.Fatal("cannot poll records from topic", zap.Error(err))
-		}
-	}()
+	checker := health.NewChecker(appKonf.Health.CheckTimeout)
+	checker.Add("mongo", func(ctx context.Context) error { return mongoClient.Ping(ctx, nil) })
+	checker.Add("redis", func(ctx context.Context) error { return redisClient.Ping(ctx).Err() })
+	checker.Add("consumer_group", txConsumer.CheckGroup)
+	checker.Add("batches", txConsumer.CheckProgress(appKonf.Health.BatchMaxAge))
+	httpServer.Handle("/healthz", checker.LivenessHandler())
+	httpServer.Handle("/readyz", checker.ReadinessHandler())
+
+	lagConfig := models.LagConfig{
+		Interval:          appKonf.Kafka.Lag.Interval,
+		Threshold:         appKonf.Kafka.Lag.Threshold,
+		ThresholdDuration: appKonf.Kafka.Lag.ThresholdDuration,
+	}
+	lagMonitor, err := kafka.NewLagMonitor(appKonf.Kafka.Brokers, conf.Name, conf.Topic, lagConfig, appMetrics, logger)
+	if err != nil {
+		logger.Fatal("cannot create lag monitor", zap.Error(err))
+	}
+	defer lagMonitor.Close()
+	go lagMonitor.Run(ctx)
+	httpServer.Handle("/admin/lag", lagMonitor.Handler())
+
+	pollErr := make(chan error, 1)
+	go func() { pollErr <- txConsumer.Poll(ctx) }()
 
-	<-ctx.Done()
+	exitCode := 0
+	if err = <-pollErr; err != nil {
+		logger.Error("cannot poll records from topic", zap.Error(err))
+		exitCode = 1
+	}
 	logger.Info("shutting down...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), appKonf.Shutdown.DrainTimeout)
 	defer cancel()
 
-	<-shutdownCtx.Done()
-	logger.Info("shutdown complete")
+	// Stop consuming first so the in-flight batches can still be written and dead-lettered
+	if err = txConsumer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("cannot shutdown consumer cleanly", zap.Error(err))
+		exitCode = 1
+	}
+	if err = mongoClient.Disconnect(shutdownCtx); err != nil {
+		logger.Error("cannot disconnect mongo client", zap.Error(err))
+		exitCode = 1
+	}
+	if err = redisClient.Close(); err != nil {
+		logger.Error("cannot close redis client", zap.Error(err))
+		exitCode = 1
+	}
+	if err = httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("cannot shutdown http server", zap.Error(err))
+		exitCode = 1
+	}
+
+	logger.Info("shutdown complete", zap.Int("exit_code", exitCode))
+	return exitCode
+}
+
+// NewMongoOptions returns the options of the mongo client and repository
+func NewMongoOptions(appKonf config.Config) mongodb.Options {
+	m := appKonf.Mongo
+	return mongodb.Options{
+		Database:          m.Database,
+		Collection:        m.Collection,
+		OffsetsCollection: m.OffsetsCollection,
+		AppName:           m.AppName,
+		ReadPreference:    m.ReadPreference,
+		WriteConcern: mongodb.WriteConcern{
+			W:        m.WriteConcern.W,
+			Journal:  m.WriteConcern.J,
+			WTimeout: m.WriteConcern.WTimeout,
+		},
+		MaxPoolSize:            m.Pool.MaxSize,
+		MinPoolSize:            m.Pool.MinSize,
+		ConnectTimeout:         m.Timeouts.Connect,
+		ServerSelectionTimeout: m.Timeouts.ServerSelection,
+		SocketTimeout:          m.Timeouts.Socket,
+	}
+}
+
+// NewTxRepository creates the transaction repository with the configured conflict policy
+func NewTxRepository(appKonf config.Config, logger *zap.Logger, mongoClient *mongo.Client) *mongodb.TxRepository {
+	policy := mongodb.ConflictPolicy(appKonf.Mongo.ConflictPolicy)
+	var lifecycle *models.Lifecycle
+	if policy == mongodb.Lifecycle {
+		var err error
+		lifecycle, err = models.NewLifecycle(appKonf.Mongo.Lifecycle.Initial, appKonf.Mongo.Lifecycle.Transitions)
+		if err != nil {
+			logger.Fatal("cannot create transaction lifecycle", zap.Error(err))
+		}
+	}
+	txRepo, err := mongodb.NewTxRepository(mongoClient, NewMongoOptions(appKonf), policy, lifecycle)
+	if err != nil {
+		logger.Fatal("cannot create transaction repository", zap.Error(err))
+	}
+	return txRepo
+}
+
+// NewTxProcessor creates the transaction processor, validating transactions when a schema is configured
+func NewTxProcessor(appKonf config.Config, logger *zap.Logger, txRepo txpsr.TxRepository) *txpsr.TxProcessor {
+	var txValidator txpsr.TxValidator
+	if appKonf.Validation.SchemaPath != "" {
+		v, err := validators.NewTxValidator(appKonf.Validation.SchemaPath)
+		if err != nil {
+			logger.Fatal("cannot load transaction schema", zap.Error(err))
+		}
+		txValidator = v
+	}
+	deserializer, err := NewDeserializer(appKonf)
+	if err != nil {
+		logger.Fatal("cannot create deserializer", zap.Error(err))
+	}
+	projection, err := appKonf.Transform.NewProjection()
+	if err != nil {
+		logger.Fatal("cannot create field projection", zap.Error(err))
+	}
+	transform := models.TransformOptions{
+		AmountFormat:     models.AmountFormat(appKonf.Transform.AmountFormat),
+		TimestampLayouts: appKonf.Transform.TimestampLayouts,
+		Projection:       projection,
+	}
+	sanitizer, err := NewSanitizer(appKonf)
+	if err != nil {
+		logger.Fatal("cannot create pii policy", zap.Error(err))
+	}
+	return txpsr.NewTxProcessor(logger, txRepo, txValidator, deserializer, sanitizer, transform)
+}
+
+// NewSanitizer creates the PII policy, nil when no fields are configured
+func NewSanitizer(appKonf config.Config) (txpsr.TxSanitizer, error) {
+	if len(appKonf.PII.Fields) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]string, len(appKonf.PII.Fields))
+	for _, field := range appKonf.PII.Fields {
+		fields[field.Path] = field.Action
+	}
+	return pii.NewPolicy([]byte(appKonf.PII.HMACKey), fields)
+}
+
+// NewDeserializer creates the deserializer selecting the format of each record, Avro and Protobuf
+// are only available when a schema registry is configured
+func NewDeserializer(appKonf config.Config) (*deserializers.Selector, error) {
+	formats := map[models.ValueFormat]deserializers.Deserializer{
+		models.FormatJSON: deserializers.JSONDeserializer{},
+	}
+
+	if registryKonf := appKonf.Deserialization.SchemaRegistry; registryKonf.URL != "" {
+		registry, err := deserializers.NewSchemaRegistry(registryKonf.URL, registryKonf.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		formats[models.FormatAvro] = deserializers.NewAvroDeserializer(registry)
+		formats[models.FormatProtobuf] = deserializers.NewProtobufDeserializer(registry)
+	}
+	return deserializers.NewSelector(models.ValueFormat(appKonf.Deserialization.Format), formats)
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	// Go Internal Packages
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	// Local Packages
+	config "tx-stream/config"
+	mongodb "tx-stream/repositories/mongodb"
+
+	// External Packages
+	"github.com/alecthomas/kingpin/v2"
+	"go.uber.org/zap"
+)
+
+var (
+	migrateCmd   = kingpin.Command("migrate", "create the transactions collection and its declared indexes")
+	migrateCheck = migrateCmd.Flag("check", "only report what is missing or drifted, exiting with 1 if anything is").Bool()
+)
+
+// migrate ensures the declared mongo schema exists, returning the process exit code
+func migrate(appKonf config.Config, logger *zap.Logger) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mongoClient, err := mongodb.Connect(ctx, appKonf.Mongo.URI, NewMongoOptions(appKonf), nil)
+	if err != nil {
+		logger.Fatal("cannot create mongo client", zap.Error(err))
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	txRepo := NewTxRepository(appKonf, logger, mongoClient)
+	report, err := ensureSchema(ctx, appKonf, txRepo, !*migrateCheck, logger)
+	if err != nil {
+		logger.Error("cannot migrate mongo schema", zap.Error(err))
+		return 1
+	}
+	if *migrateCheck && report.Drifted() {
+		return 1
+	}
+	return 0
+}
+
+// ensureSchema creates the declared collection and missing indexes, or only checks for them, logging
+// what was created and how the collection drifted from the declarations
+func ensureSchema(ctx context.Context, appKonf config.Config, txRepo *mongodb.TxRepository, apply bool,
+	logger *zap.Logger) (mongodb.SchemaReport, error) {
+	schema, err := newMongoSchema(appKonf.Mongo.Schema)
+	if err != nil {
+		return mongodb.SchemaReport{}, err
+	}
+	report, err := txRepo.EnsureSchema(ctx, schema, apply)
+	if err != nil {
+		return report, err
+	}
+
+	collection := zap.String("collection", appKonf.Mongo.Collection)
+	if report.CreatedCollection {
+		logger.Info("created transactions collection", collection)
+	}
+	if len(report.CreatedIndexes) > 0 {
+		logger.Info("created indexes", collection, zap.Strings("indexes", report.CreatedIndexes))
+	}
+	if len(report.MissingIndexes) > 0 {
+		logger.Warn("indexes are missing", collection, zap.Strings("indexes", report.MissingIndexes))
+	}
+	if len(report.UndeclaredIndexes) > 0 {
+		logger.Warn("indexes are not declared", collection, zap.Strings("indexes", report.UndeclaredIndexes))
+	}
+	for _, drift := range report.Drift {
+		logger.Warn("mongo schema drifted", collection, zap.String("drift", drift))
+	}
+	if !report.Drifted() {
+		logger.Info("mongo schema is up to date", collection)
+	}
+	return report, nil
+}
+
+// newMongoSchema returns the declared schema of the transactions collection, reading the validator schema if any
+func newMongoSchema(s config.MongoSchema) (mongodb.Schema, error) {
+	schema := mongodb.Schema{
+		Indexes: make([]mongodb.Index, len(s.Indexes)),
+		TimeSeries: mongodb.TimeSeries{
+			TimeField:   s.TimeSeries.TimeField,
+			MetaField:   s.TimeSeries.MetaField,
+			Granularity: s.TimeSeries.Granularity,
+		},
+		Validator: mongodb.Validator{Level: s.Validator.Level, Action: s.Validator.Action},
+	}
+	for idx, index := range s.Indexes {
+		schema.Indexes[idx] = mongodb.Index{Name: index.Name, Keys: index.Keys, Unique: index.Unique}
+	}
+	if s.Validator.SchemaPath != "" {
+		validator, err := mongodb.LoadValidator(s.Validator.SchemaPath)
+		if err != nil {
+			return schema, err
+		}
+		schema.Validator.Schema = validator
+	}
+	return schema, nil
+}
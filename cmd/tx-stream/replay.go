@@ -0,0 +1,111 @@
+package main
+
+import (
+	// Go Internal Packages
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	// Local Packages
+	config "tx-stream/config"
+	kafka "tx-stream/kafka"
+	mongodb "tx-stream/repositories/mongodb"
+	redis "tx-stream/repositories/redis"
+	replaysvc "tx-stream/services/replay"
+
+	// External Packages
+	"go.uber.org/zap"
+)
+
+var (
+	replayCmd         = dlqCmd.Command("replay", "reprocess dead-lettered records once the cause of the failure is fixed")
+	replayKey         = replayCmd.Flag("key", "only replay records whose key matches this glob pattern").String()
+	replaySince       = replayCmd.Flag("since", "only replay records which failed at or after this RFC3339 time").String()
+	replayUntil       = replayCmd.Flag("until", "only replay records which failed before this RFC3339 time").String()
+	replayReason      = replayCmd.Flag("reason", "only replay records whose failure reason contains this text").String()
+	replayDryRun      = replayCmd.Flag("dry-run", "only log the records which would be replayed").Bool()
+	replayRate        = replayCmd.Flag("rate", "maximum records replayed per second, 0 for unlimited").Default("100").Float64()
+	replayBatchSize   = replayCmd.Flag("batch-size", "records read from the dead-letter queue at a time").Default("100").Int()
+	replayIdleTimeout = replayCmd.Flag("idle-timeout", "stop once no records arrive for this long, kafka backend only").Default("10s").Duration()
+)
+
+// replay reprocesses the dead-lettered records matching the flags, returning the process exit code
+func replay(appKonf config.Config, logger *zap.Logger) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	filter := replaysvc.Filter{KeyPattern: *replayKey, Reason: *replayReason}
+	var err error
+	if filter.Since, err = parseTime(*replaySince); err != nil {
+		logger.Fatal("invalid --since time", zap.Error(err))
+	}
+	if filter.Until, err = parseTime(*replayUntil); err != nil {
+		logger.Fatal("invalid --until time", zap.Error(err))
+	}
+
+	var source replaysvc.DeadLetterSource
+	switch appKonf.DLQ.Backend {
+	case "kafka":
+		consumer, err := kafka.NewDeadLetterConsumer(appKonf.Kafka.Brokers, appKonf.DLQ.Kafka.Topic,
+			appKonf.DLQ.Kafka.ReplayGroup, *replayIdleTimeout)
+		if err != nil {
+			logger.Fatal("cannot create dlq consumer", zap.Error(err))
+		}
+		defer consumer.Close()
+		source = consumer
+	default:
+		redisClient, err := redis.Connect(ctx, appKonf.Redis.URI, appKonf.Redis.Password)
+		if err != nil {
+			logger.Fatal("cannot create redis client", zap.Error(err))
+		}
+		defer redisClient.Close()
+
+		dlq := appKonf.Redis.DLQ
+		source = redis.NewDeadLetterQueue(redisClient, dlq.Stream, dlq.Group, dlq.MaxLen, logger).Cursor()
+	}
+
+	// A dry run only reads the dead-letter queue, so it does not need mongo
+	var processor replaysvc.TxProcessor
+	if !*replayDryRun {
+		mongoClient, err := mongodb.Connect(ctx, appKonf.Mongo.URI, NewMongoOptions(appKonf), nil)
+		if err != nil {
+			logger.Fatal("cannot create mongo client", zap.Error(err))
+		}
+		defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+		txRepo := NewTxRepository(appKonf, logger, mongoClient)
+		processor = NewTxProcessor(appKonf, logger, txRepo)
+	}
+
+	options := replaysvc.Options{
+		Filter:    filter,
+		DryRun:    *replayDryRun,
+		Rate:      *replayRate,
+		BatchSize: *replayBatchSize,
+	}
+	summary, err := replaysvc.NewReplayer(source, processor, options, logger).Run(ctx)
+
+	fields := []zap.Field{
+		zap.Int("scanned", summary.Scanned),
+		zap.Int("matched", summary.Matched),
+		zap.Int("replayed", summary.Replayed),
+		zap.Int("failed", summary.Failed),
+		zap.Bool("dry_run", options.DryRun),
+	}
+	if err != nil {
+		logger.Error("dlq replay stopped", append(fields, zap.Error(err))...)
+		return 1
+	}
+	logger.Info("dlq replay complete", fields...)
+	return 0
+}
+
+// parseTime parses an RFC3339 time, an empty string is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -1,8 +1,14 @@
 package config
 
 import (
+	// Go Internal Packages
+	"fmt"
+	"strings"
+	"time"
+
 	// Local Packages
 	errors "tx-stream/errors"
+	models "tx-stream/models"
 )
 
 var DefaultConfig = []byte(`
@@ -13,12 +19,98 @@ logger:
 
 is_prod_mode: false
 
+shutdown:
+  drain_timeout: "30s"
+
+http:
+  address: ":8080"
+
+health:
+  check_timeout: "2s"
+  batch_max_age: "5m"
+
 mongo:
   uri: "mongodb://localhost:27017"
+  database: "flipkart-db"
+  collection: "transactions"
+  offsets_collection: "offsets"
+  app_name: "tx-stream"
+  read_preference: "primary"
+  write_concern:
+    w: "majority"
+    j: true
+    wtimeout: "5s"
+  pool:
+    max_size: 100
+    min_size: 0
+  timeouts:
+    connect: "10s"
+    server_selection: "5s"
+    socket: "0s"
+  schema:
+    bootstrap: true
+    indexes:
+      - keys: ["user_id", "timestamp"]
+      - keys: ["status"]
+      - keys: ["merchant_name", "timestamp"]
+    time_series:
+      time_field: ""
+      meta_field: ""
+      granularity: ""
+    validator:
+      schema_path: ""
+      level: ""
+      action: ""
+  conflict_policy: "keep_first"
+  lifecycle:
+    initial: ["pending"]
+    transitions:
+      pending: ["success", "failed"]
+      success: ["refunded"]
+  breaker:
+    failure_threshold: 3
+    probe_interval: "10s"
 
 redis:
   uri: "localhost:6379"
   password: ""
+  dlq:
+    stream: "dlq:transactions"
+    group: "dlq-triage"
+    max_len: 1000000
+
+dlq:
+  backend: "redis"
+  kafka:
+    topic: "transactions-dlq"
+    replay_group: "tx-consumer-dlq-replay"
+
+validation:
+  schema_path: "schemas/transaction.schema.json"
+
+deserialization:
+  format: "json"
+  schema_registry:
+    url: ""
+    timeout: "5s"
+
+transform:
+  amount_format: "decimal"
+  timestamp_layouts:
+    - "2006-01-02T15:04:05.999999999Z07:00"
+  projection:
+    include: []
+    exclude: []
+    fields: []
+    keep_extra: false
+
+pii:
+  hmac_key: ""
+  fields:
+    - path: "card_number"
+      action: "mask"
+    - path: "ip_address"
+      action: "drop"
 
 kafka:
   brokers:
@@ -27,38 +119,292 @@ kafka:
   topic: "transactions"
   channel_size: 1000
   records_per_poll: 5000
+  batch:
+    max_records: 500
+    max_bytes: 1048576
+    linger: "200ms"
+  parallel:
+    concurrency: 1
+    key_by: "record_key"
+  backpressure:
+    high_watermark: 800
+    low_watermark: 200
   consumer_name: "tx-consumer"
+  exactly_once: false
+  lag:
+    interval: "30s"
+    threshold: 10000
+    threshold_duration: "5m"
+  retry:
+    max_attempts: 3
+    base_backoff: "1s"
+    max_backoff: "16s"
+    jitter: "equal"
+    deadline: "60s"
 `)
 
 type Config struct {
-	Application string `koanf:"application"`
-	Logger      Logger `koanf:"logger"`
-	IsProdMode  bool   `koanf:"is_prod_mode"`
-	Mongo       Mongo  `koanf:"mongo"`
-	Redis       Redis  `koanf:"redis"`
-	Kafka       Kafka  `koanf:"kafka"`
+	Application     string          `koanf:"application"`
+	Logger          Logger          `koanf:"logger"`
+	IsProdMode      bool            `koanf:"is_prod_mode"`
+	Shutdown        Shutdown        `koanf:"shutdown"`
+	HTTP            HTTP            `koanf:"http"`
+	Health          Health          `koanf:"health"`
+	Mongo           Mongo           `koanf:"mongo"`
+	Redis           Redis           `koanf:"redis"`
+	DLQ             DLQ             `koanf:"dlq"`
+	Validation      Validation      `koanf:"validation"`
+	Deserialization Deserialization `koanf:"deserialization"`
+	Transform       Transform       `koanf:"transform"`
+	PII             PII             `koanf:"pii"`
+	Kafka           Kafka           `koanf:"kafka"`
 }
 
 type Logger struct {
 	Level string `koanf:"level"`
 }
 
+// Shutdown configures how long in-flight batches are given to finish on shutdown.
+type Shutdown struct {
+	DrainTimeout time.Duration `koanf:"drain_timeout"`
+}
+
+// HTTP configures the embedded server exposing the metrics and health endpoints.
+type HTTP struct {
+	Address string `koanf:"address"`
+}
+
+// Health configures the readiness checks, the service is not ready once batches
+// have been failing without a success for longer than batch_max_age.
+type Health struct {
+	CheckTimeout time.Duration `koanf:"check_timeout"`
+	BatchMaxAge  time.Duration `koanf:"batch_max_age"`
+}
+
+// Mongo configures the mongo client and where transactions and offsets are stored. The options here
+// override the same options in the uri, a socket timeout of zero never times out.
 type Mongo struct {
-	URI string `koanf:"uri"`
+	URI               string            `koanf:"uri"`
+	Database          string            `koanf:"database"`
+	Collection        string            `koanf:"collection"`
+	OffsetsCollection string            `koanf:"offsets_collection"`
+	AppName           string            `koanf:"app_name"`
+	ReadPreference    string            `koanf:"read_preference"`
+	WriteConcern      MongoWriteConcern `koanf:"write_concern"`
+	Pool              MongoPool         `koanf:"pool"`
+	Timeouts          MongoTimeouts     `koanf:"timeouts"`
+	Schema            MongoSchema       `koanf:"schema"`
+	ConflictPolicy    string            `koanf:"conflict_policy"`
+	Lifecycle         MongoLifecycle    `koanf:"lifecycle"`
+	Breaker           Breaker           `koanf:"breaker"`
+}
+
+// MongoLifecycle is the state machine of the lifecycle conflict policy, a transaction is first stored with one
+// of the initial statuses and then only moves from a status to the statuses listed under it in transitions
+type MongoLifecycle struct {
+	Initial     []string            `koanf:"initial"`
+	Transitions map[string][]string `koanf:"transitions"`
+}
+
+// MongoWriteConcern is the acknowledgment requested for writes, w is "majority" or the number of members
+type MongoWriteConcern struct {
+	W        string        `koanf:"w"`
+	J        bool          `koanf:"j"`
+	WTimeout time.Duration `koanf:"wtimeout"`
+}
+
+type MongoPool struct {
+	MaxSize uint64 `koanf:"max_size"`
+	MinSize uint64 `koanf:"min_size"`
+}
+
+type MongoTimeouts struct {
+	Connect         time.Duration `koanf:"connect"`
+	ServerSelection time.Duration `koanf:"server_selection"`
+	Socket          time.Duration `koanf:"socket"`
+}
+
+// MongoSchema declares the indexes of the transactions collection and how it is created when it does not exist.
+// With bootstrap set the collection and missing indexes are created at startup, drift is only logged.
+type MongoSchema struct {
+	Bootstrap  bool            `koanf:"bootstrap"`
+	Indexes    []MongoIndex    `koanf:"indexes"`
+	TimeSeries MongoTimeSeries `koanf:"time_series"`
+	Validator  MongoValidator  `koanf:"validator"`
+}
+
+// MongoIndex is an index on keys in order, descending for the keys prefixed with '-', named after them when
+// name is empty
+type MongoIndex struct {
+	Name   string   `koanf:"name"`
+	Keys   []string `koanf:"keys"`
+	Unique bool     `koanf:"unique"`
+}
+
+// MongoTimeSeries creates the collection as a time-series collection when time_field is set
+type MongoTimeSeries struct {
+	TimeField   string `koanf:"time_field"`
+	MetaField   string `koanf:"meta_field"`
+	Granularity string `koanf:"granularity"`
+}
+
+// MongoValidator creates the collection with the $jsonSchema in the extended JSON file at schema_path
+type MongoValidator struct {
+	SchemaPath string `koanf:"schema_path"`
+	Level      string `koanf:"level"`
+	Action     string `koanf:"action"`
+}
+
+// Breaker configures the circuit breaker around a store, it opens after failure_threshold consecutive
+// failures and probes the store every probe_interval until it recovers. A zero threshold disables it.
+type Breaker struct {
+	FailureThreshold int           `koanf:"failure_threshold"`
+	ProbeInterval    time.Duration `koanf:"probe_interval"`
 }
 
 type Redis struct {
-	URI      string `koanf:"uri"`
-	Password string `koanf:"password"`
+	URI      string   `koanf:"uri"`
+	Password string   `koanf:"password"`
+	DLQ      RedisDLQ `koanf:"dlq"`
+}
+
+// RedisDLQ configures the stream backing the redis dead-letter queue, max_len of zero leaves it uncapped.
+type RedisDLQ struct {
+	Stream string `koanf:"stream"`
+	Group  string `koanf:"group"`
+	MaxLen int64  `koanf:"max_len"`
+}
+
+// DLQ selects where records which could not be processed are sent, either "redis" or "kafka".
+type DLQ struct {
+	Backend string   `koanf:"backend"`
+	Kafka   KafkaDLQ `koanf:"kafka"`
+}
+
+type KafkaDLQ struct {
+	Topic       string `koanf:"topic"`
+	ReplayGroup string `koanf:"replay_group"`
+}
+
+// Deserialization configures how record values are decoded, format is used for records without a
+// content-type header. Avro and Protobuf values resolve their schema from the schema registry.
+type Deserialization struct {
+	Format         string         `koanf:"format"`
+	SchemaRegistry SchemaRegistry `koanf:"schema_registry"`
+}
+
+type SchemaRegistry struct {
+	URL     string        `koanf:"url"`
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// Transform configures how transactions are stored, amounts as exact decimals (decimal) or integer
+// minor units of the currency (minor_units), and which layouts timestamps are parsed with, in order.
+type Transform struct {
+	AmountFormat     string     `koanf:"amount_format"`
+	TimestampLayouts []string   `koanf:"timestamp_layouts"`
+	Projection       Projection `koanf:"projection"`
+}
+
+// Projection configures which fields are stored besides the id, amount and timestamp. Every field of a
+// transaction is stored under its own name unless include lists the dotted paths to store, exclude lists
+// the paths never stored, and fields renames and coerces single paths. Fields a transaction is not known
+// to have are kept under extra when keep_extra is set.
+type Projection struct {
+	Include   []string          `koanf:"include"`
+	Exclude   []string          `koanf:"exclude"`
+	Fields    []ProjectionField `koanf:"fields"`
+	KeepExtra bool              `koanf:"keep_extra"`
+}
+
+// ProjectionField stores the field at the dotted path from under the dotted path to, coerced into type
+// (string, int, float, bool, decimal, amount or timestamp), kept as is when type is empty
+type ProjectionField struct {
+	From string `koanf:"from"`
+	To   string `koanf:"to"`
+	Type string `koanf:"type"`
+}
+
+// NewProjection builds the projection of the stored fields
+func (t Transform) NewProjection() (*models.Projection, error) {
+	mappings := make([]models.FieldMapping, len(t.Projection.Fields))
+	for idx, field := range t.Projection.Fields {
+		mappings[idx] = models.FieldMapping{From: field.From, To: field.To, Type: models.FieldType(field.Type)}
+	}
+	return models.NewProjection(t.Projection.Include, t.Projection.Exclude, mappings, t.Projection.KeepExtra)
+}
+
+// PII configures how personal data is sanitized before it is validated, stored, logged or dead-lettered.
+// The hmac_key is required by the hash and tokenize actions.
+type PII struct {
+	HMACKey string     `koanf:"hmac_key"`
+	Fields  []PIIField `koanf:"fields"`
+}
+
+// PIIField is the action (mask, hash, tokenize or drop) applied to the field at the dotted JSON path
+type PIIField struct {
+	Path   string `koanf:"path"`
+	Action string `koanf:"action"`
+}
+
+// Validation configures the validation of incoming transactions, skipped when schema_path is empty.
+type Validation struct {
+	SchemaPath string `koanf:"schema_path"`
 }
 
 type Kafka struct {
-	Brokers        []string `koanf:"brokers"`
-	Consume        bool     `koanf:"consume"`
-	Topic          string   `koanf:"topic"`
-	ChannelSize    int      `koanf:"channel_size"`
-	RecordsPerPoll int      `koanf:"records_per_poll"`
-	ConsumerName   string   `koanf:"consumer_name"`
+	Brokers        []string     `koanf:"brokers"`
+	Consume        bool         `koanf:"consume"`
+	Topic          string       `koanf:"topic"`
+	ChannelSize    int          `koanf:"channel_size"`
+	RecordsPerPoll int          `koanf:"records_per_poll"`
+	ConsumerName   string       `koanf:"consumer_name"`
+	ExactlyOnce    bool         `koanf:"exactly_once"`
+	Batch          Batch        `koanf:"batch"`
+	Parallel       Parallel     `koanf:"parallel"`
+	Backpressure   Backpressure `koanf:"backpressure"`
+	Lag            Lag          `koanf:"lag"`
+	Retry          Retry        `koanf:"retry"`
+}
+
+// Batch configures the micro-batches written to mongo, records are accumulated per partition until
+// max_records or max_bytes is reached or linger has passed since the first record.
+type Batch struct {
+	MaxRecords int           `koanf:"max_records"`
+	MaxBytes   int           `koanf:"max_bytes"`
+	Linger     time.Duration `koanf:"linger"`
+}
+
+// Parallel configures the workers processing each partition, records are ordered by key_by,
+// either record_key or user_id, which is read from JSON values only. Parallel processing is not
+// supported in exactly-once mode.
+type Parallel struct {
+	Concurrency int    `koanf:"concurrency"`
+	KeyBy       string `koanf:"key_by"`
+}
+
+// Backpressure configures when fetching is paused for a partition, the watermarks are the number
+// of fetched batches queued for the partition consumer. The high watermark is below channel_size,
+// so fetching is paused before the queue is full and polling never blocks on it.
+type Backpressure struct {
+	HighWatermark int `koanf:"high_watermark"`
+	LowWatermark  int `koanf:"low_watermark"`
+}
+
+// Lag configures the consumer lag reporting, a warning is logged once the lag of a
+// partition stays above threshold for longer than threshold_duration.
+type Lag struct {
+	Interval          time.Duration `koanf:"interval"`
+	Threshold         int64         `koanf:"threshold"`
+	ThresholdDuration time.Duration `koanf:"threshold_duration"`
+}
+
+type Retry struct {
+	MaxAttempts int           `koanf:"max_attempts"`
+	BaseBackoff time.Duration `koanf:"base_backoff"`
+	MaxBackoff  time.Duration `koanf:"max_backoff"`
+	Jitter      string        `koanf:"jitter"`
+	Deadline    time.Duration `koanf:"deadline"`
 }
 
 // Validate validates the configuration
@@ -71,15 +417,195 @@ func (c *Config) Validate() error {
 	if c.Logger.Level == "" {
 		ve.Add("logger.level", "cannot be empty")
 	}
+	if c.Shutdown.DrainTimeout <= 0 {
+		ve.Add("shutdown.drain_timeout", "must be positive")
+	}
+	if c.HTTP.Address == "" {
+		ve.Add("http.address", "cannot be empty")
+	}
+	if c.Health.CheckTimeout <= 0 {
+		ve.Add("health.check_timeout", "must be positive")
+	}
+	if c.Health.BatchMaxAge <= 0 {
+		ve.Add("health.batch_max_age", "must be positive")
+	}
 	if c.Mongo.URI == "" {
 		ve.Add("mongo.uri", "cannot be empty")
 	}
+	if c.Mongo.Database == "" {
+		ve.Add("mongo.database", "cannot be empty")
+	}
+	if c.Mongo.Collection == "" {
+		ve.Add("mongo.collection", "cannot be empty")
+	}
+	if c.Mongo.OffsetsCollection == "" {
+		ve.Add("mongo.offsets_collection", "cannot be empty")
+	}
+	if c.Mongo.Collection != "" && c.Mongo.Collection == c.Mongo.OffsetsCollection {
+		ve.Add("mongo.offsets_collection", "must differ from mongo.collection")
+	}
+	switch strings.ToLower(c.Mongo.ReadPreference) {
+	case "primary":
+	case "primarypreferred", "secondary", "secondarypreferred", "nearest":
+		if c.Kafka.ExactlyOnce {
+			ve.Add("mongo.read_preference", "must be primary in exactly-once mode")
+		}
+	default:
+		ve.Add("mongo.read_preference", "must be one of primary, primaryPreferred, secondary, secondaryPreferred, nearest")
+	}
+	if c.Mongo.WriteConcern.WTimeout < 0 {
+		ve.Add("mongo.write_concern.wtimeout", "cannot be negative")
+	}
+	for idx, index := range c.Mongo.Schema.Indexes {
+		if len(index.Keys) == 0 {
+			ve.Add(fmt.Sprintf("mongo.schema.indexes[%d].keys", idx), "cannot be empty")
+			continue
+		}
+		for _, key := range index.Keys {
+			if strings.TrimPrefix(key, "-") == "" {
+				ve.Add(fmt.Sprintf("mongo.schema.indexes[%d].keys", idx), "cannot hold empty keys")
+			}
+		}
+	}
+	if ts := c.Mongo.Schema.TimeSeries; ts.TimeField == "" && (ts.MetaField != "" || ts.Granularity != "") {
+		ve.Add("mongo.schema.time_series.time_field", "is required for a time-series collection")
+	}
+	switch c.Mongo.Schema.TimeSeries.Granularity {
+	case "", "seconds", "minutes", "hours":
+	default:
+		ve.Add("mongo.schema.time_series.granularity", "must be one of seconds, minutes, hours")
+	}
+	switch c.Mongo.Schema.Validator.Level {
+	case "", "off", "strict", "moderate":
+	default:
+		ve.Add("mongo.schema.validator.level", "must be one of off, strict, moderate")
+	}
+	switch c.Mongo.Schema.Validator.Action {
+	case "", "error", "warn":
+	default:
+		ve.Add("mongo.schema.validator.action", "must be one of error, warn")
+	}
+	if c.Mongo.Pool.MaxSize > 0 && c.Mongo.Pool.MinSize > c.Mongo.Pool.MaxSize {
+		ve.Add("mongo.pool.min_size", "cannot exceed mongo.pool.max_size")
+	}
+	if c.Mongo.Timeouts.Connect < 0 {
+		ve.Add("mongo.timeouts.connect", "cannot be negative")
+	}
+	if c.Mongo.Timeouts.ServerSelection < 0 {
+		ve.Add("mongo.timeouts.server_selection", "cannot be negative")
+	}
+	if c.Mongo.Timeouts.Socket < 0 {
+		ve.Add("mongo.timeouts.socket", "cannot be negative")
+	}
+	if c.Mongo.Breaker.FailureThreshold < 0 {
+		ve.Add("mongo.breaker.failure_threshold", "cannot be negative")
+	}
+	if c.Mongo.Breaker.FailureThreshold > 0 && c.Mongo.Breaker.ProbeInterval <= 0 {
+		ve.Add("mongo.breaker.probe_interval", "must be positive")
+	}
 	if c.Redis.URI == "" {
 		ve.Add("redis.uri", "cannot be empty")
 	}
+	switch c.DLQ.Backend {
+	case "redis":
+		if c.Redis.DLQ.Stream == "" {
+			ve.Add("redis.dlq.stream", "cannot be empty")
+		}
+		if c.Redis.DLQ.Group == "" {
+			ve.Add("redis.dlq.group", "cannot be empty")
+		}
+		if c.Redis.DLQ.MaxLen < 0 {
+			ve.Add("redis.dlq.max_len", "cannot be negative")
+		}
+	case "kafka":
+		if c.DLQ.Kafka.Topic == "" {
+			ve.Add("dlq.kafka.topic", "cannot be empty")
+		}
+		if c.DLQ.Kafka.ReplayGroup == "" {
+			ve.Add("dlq.kafka.replay_group", "cannot be empty")
+		}
+	default:
+		ve.Add("dlq.backend", "must be one of redis, kafka")
+	}
 	if len(c.Kafka.Brokers) == 0 {
 		ve.Add("kafka.brokers", "cannot be empty")
 	}
+	format := models.ValueFormat(c.Deserialization.Format)
+	if !format.IsValid() {
+		ve.Add("deserialization.format", "must be one of json, avro, protobuf")
+	}
+	if format != models.FormatJSON && c.Deserialization.SchemaRegistry.URL == "" {
+		ve.Add("deserialization.schema_registry.url", "is required for avro and protobuf")
+	}
+	if !models.AmountFormat(c.Transform.AmountFormat).IsValid() {
+		ve.Add("transform.amount_format", "must be one of decimal, minor_units")
+	}
+	if len(c.Transform.TimestampLayouts) == 0 {
+		ve.Add("transform.timestamp_layouts", "cannot be empty")
+	}
+	if _, err := c.Transform.NewProjection(); err != nil {
+		ve.Add("transform.projection", err.Error())
+	}
+	for idx, field := range c.PII.Fields {
+		if field.Path == "" {
+			ve.Add(fmt.Sprintf("pii.fields[%d].path", idx), "cannot be empty")
+		}
+		if field.Action == "" {
+			ve.Add(fmt.Sprintf("pii.fields[%d].action", idx), "cannot be empty")
+		}
+	}
+	if c.Kafka.Batch.MaxRecords < 1 {
+		ve.Add("kafka.batch.max_records", "must be positive")
+	}
+	if c.Kafka.Batch.MaxBytes < 1 {
+		ve.Add("kafka.batch.max_bytes", "must be positive")
+	}
+	if c.Kafka.Batch.Linger <= 0 {
+		ve.Add("kafka.batch.linger", "must be positive")
+	}
+	if c.Kafka.Parallel.Concurrency < 1 {
+		ve.Add("kafka.parallel.concurrency", "must be positive")
+	}
+	if c.Kafka.Parallel.Concurrency > 1 && c.Kafka.ExactlyOnce {
+		ve.Add("kafka.parallel.concurrency", "must be 1 in exactly-once mode")
+	}
+	keyBy := models.KeyBy(c.Kafka.Parallel.KeyBy)
+	if !keyBy.IsValid() {
+		ve.Add("kafka.parallel.key_by", "must be one of record_key, user_id")
+	}
+	if keyBy == models.KeyByUserID && format != models.FormatJSON {
+		ve.Add("kafka.parallel.key_by", "user_id requires deserialization.format json")
+	}
+	if c.Kafka.ChannelSize < 1 {
+		ve.Add("kafka.channel_size", "must be positive")
+	}
+	if bp := c.Kafka.Backpressure; bp.HighWatermark < 1 || bp.HighWatermark >= c.Kafka.ChannelSize {
+		ve.Add("kafka.backpressure.high_watermark", "must be at least 1 and below kafka.channel_size")
+	}
+	if bp := c.Kafka.Backpressure; bp.LowWatermark < 0 || bp.LowWatermark >= bp.HighWatermark {
+		ve.Add("kafka.backpressure.low_watermark", "must be between 0 and kafka.backpressure.high_watermark")
+	}
+	if c.Kafka.Lag.Interval <= 0 {
+		ve.Add("kafka.lag.interval", "must be positive")
+	}
+	if c.Kafka.Lag.Threshold < 0 {
+		ve.Add("kafka.lag.threshold", "cannot be negative")
+	}
+	if c.Kafka.Retry.MaxAttempts < 1 {
+		ve.Add("kafka.retry.max_attempts", "must be at least 1")
+	}
+	if c.Kafka.Retry.BaseBackoff < 0 {
+		ve.Add("kafka.retry.base_backoff", "cannot be negative")
+	}
+	if c.Kafka.Retry.MaxBackoff < c.Kafka.Retry.BaseBackoff {
+		ve.Add("kafka.retry.max_backoff", "cannot be less than base_backoff")
+	}
+	if !models.Jitter(c.Kafka.Retry.Jitter).IsValid() {
+		ve.Add("kafka.retry.jitter", "must be one of none, full, equal")
+	}
+	if c.Kafka.Retry.Deadline < 0 {
+		ve.Add("kafka.retry.deadline", "cannot be negative")
+	}
 
 	return ve.Err()
 }
@@ -56,6 +56,8 @@ func (k Kind) String() string {
 		return "unclassified error"
 	case Internal:
 		return "internal error"
+	case Conflict:
+		return "conflict"
 	case Invalid:
 		return "invalid input"
 	case NotFound:
@@ -86,6 +88,15 @@ func E(args ...interface{}) error {
 	return e
 }
 
+// IsPermanent reports whether the error is of a kind that can never succeed when retried
+func IsPermanent(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Kind == Invalid || e.Kind == Conflict
+}
+
 var (
 	As = errors.As
 	Is = errors.Is
@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// DeadLetterConsumer reads the dead letters back from the DLQ topic to replay them. Acknowledging an
+// entry commits the group's offset past it, but never past an earlier entry of the same partition
+// which was not acknowledged, so unacknowledged entries are read again by the next replay. Entries
+// left out by a replay filter are never acknowledged, so they are not lost to the group.
+type DeadLetterConsumer struct {
+	client      *kgo.Client
+	idleTimeout time.Duration
+	fetched     map[int32][]*kgo.Record
+	acked       map[string]bool
+}
+
+// NewDeadLetterConsumer creates a consumer of the DLQ topic in the given group. Next reports the topic
+// as exhausted once no records arrive within idleTimeout.
+func NewDeadLetterConsumer(brokers []string, topic, group string, idleTimeout time.Duration) (*DeadLetterConsumer, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.DisableAutoCommit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterConsumer{
+		client:      client,
+		idleTimeout: idleTimeout,
+		fetched:     make(map[int32][]*kgo.Record),
+		acked:       make(map[string]bool),
+	}, nil
+}
+
+// Next returns the next batch of up to count entries, an empty batch once the topic is exhausted
+func (c *DeadLetterConsumer) Next(ctx context.Context, count int) ([]models.DeadLetterEntry, error) {
+	pollCtx, cancel := context.WithTimeout(ctx, c.idleTimeout)
+	defer cancel()
+
+	fetches := c.client.PollRecords(pollCtx, count)
+	if fetches.IsClientClosed() {
+		return nil, errors.NewError("kafka client closed")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := fetches.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	var entries []models.DeadLetterEntry
+	fetches.EachRecord(func(record *kgo.Record) {
+		c.fetched[record.Partition] = append(c.fetched[record.Partition], record)
+		entries = append(entries, models.DeadLetterEntry{ID: recordID(record), DeadLetter: DeadLetterFromRecord(record)})
+	})
+	return entries, nil
+}
+
+// Ack commits the group's offset past the acknowledged entries, up to the first unacknowledged entry of each partition
+func (c *DeadLetterConsumer) Ack(ctx context.Context, entries []models.DeadLetterEntry) error {
+	for _, entry := range entries {
+		c.acked[entry.ID] = true
+	}
+
+	var commit []*kgo.Record
+	for partition, records := range c.fetched {
+		done := 0
+		for done < len(records) && c.acked[recordID(records[done])] {
+			delete(c.acked, recordID(records[done]))
+			done++
+		}
+		if done > 0 {
+			commit = append(commit, records[done-1])
+			c.fetched[partition] = records[done:]
+		}
+	}
+
+	if len(commit) == 0 {
+		return nil
+	}
+	return c.client.CommitRecords(ctx, commit...)
+}
+
+// Close leaves the group and closes the client
+func (c *DeadLetterConsumer) Close() {
+	c.client.Close()
+}
+
+// DeadLetterFromRecord rebuilds the dead letter from a record published by the DeadLetterProducer
+func DeadLetterFromRecord(record *kgo.Record) models.DeadLetter {
+	dl := models.DeadLetter{Record: models.Record{Key: record.Key, Value: record.Value}}
+	for _, header := range record.Headers {
+		value := string(header.Value)
+		switch header.Key {
+		case HeaderSourceTopic:
+			dl.Record.Topic = value
+		case HeaderSourcePartition:
+			partition, _ := strconv.ParseInt(value, 10, 32)
+			dl.Record.Partition = int32(partition)
+		case HeaderSourceOffset:
+			dl.Record.Offset, _ = strconv.ParseInt(value, 10, 64)
+		case HeaderSourceTimestamp:
+			dl.Record.Timestamp, _ = time.Parse(time.RFC3339Nano, value)
+		case HeaderError:
+			dl.Reason = value
+		case HeaderErrorDetails:
+			_ = json.Unmarshal(header.Value, &dl.Details)
+		case HeaderAttempts:
+			dl.Attempts, _ = strconv.Atoi(value)
+		case HeaderFailedAt:
+			dl.FailedAt, _ = time.Parse(time.RFC3339Nano, value)
+		default:
+			if !strings.HasPrefix(header.Key, HeaderPrefix) {
+				dl.Record.Headers = append(dl.Record.Headers, models.RecordHeader{Key: header.Key, Value: header.Value})
+			}
+		}
+	}
+	return dl
+}
+
+func recordID(record *kgo.Record) string {
+	return fmt.Sprintf("%d:%d", record.Partition, record.Offset)
+}
@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"testing"
+	"time"
+
+	// Local Packages
+	models "tx-stream/models"
+	replaysvc "tx-stream/services/replay"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// succeedingProcessor reprocesses every record successfully
+type succeedingProcessor struct{}
+
+func (succeedingProcessor) ProcessRecords(_ context.Context, records []models.Record) []models.RecordResult {
+	results := make([]models.RecordResult, len(records))
+	for idx, record := range records {
+		results[idx] = models.RecordResult{Record: record, Outcome: models.Succeeded}
+	}
+	return results
+}
+
+func TestDeadLetterReplayKeepsFilteredEntries(t *testing.T) {
+	h := newHarness(t, 1)
+	h.produce(
+		&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("skip-1"), Value: []byte("{}")},
+		&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("replay-1"), Value: []byte("{}")},
+	)
+
+	replay := func(filter replaysvc.Filter) replaysvc.Summary {
+		t.Helper()
+		consumer, err := NewDeadLetterConsumer(h.brokers, testTopic, "replay", 500*time.Millisecond)
+		if err != nil {
+			t.Fatalf("cannot create dead letter consumer: %v", err)
+		}
+		defer consumer.Close()
+
+		options := replaysvc.Options{Filter: filter, BatchSize: 10}
+		summary, err := replaysvc.NewReplayer(consumer, succeedingProcessor{}, options, zap.NewNop()).Run(context.Background())
+		if err != nil {
+			t.Fatalf("replay failed: %v", err)
+		}
+		return summary
+	}
+
+	if summary := replay(replaysvc.Filter{KeyPattern: "replay-*"}); summary.Matched != 1 || summary.Replayed != 1 {
+		t.Fatalf("filtered replay = %+v, want 1 matched and replayed", summary)
+	}
+	if committed, ok := h.committed("replay")[0]; ok {
+		t.Errorf("committed offset = %d past the filtered entry", committed)
+	}
+
+	// the filtered entry is still there for a replay without the filter
+	if summary := replay(replaysvc.Filter{}); summary.Scanned != 2 || summary.Replayed != 2 {
+		t.Errorf("unfiltered replay = %+v, want both entries replayed", summary)
+	}
+	if committed := h.committed("replay"); committed[0] != 2 {
+		t.Errorf("committed offset = %d, want 2 past every replayed entry", committed[0])
+	}
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	// Local Packages
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// Headers added to every dead-lettered record describing where it came from and why it failed,
+// the original headers of the record are kept as they are
+const (
+	HeaderPrefix          = "dlq."
+	HeaderSourceTopic     = "dlq.source.topic"
+	HeaderSourcePartition = "dlq.source.partition"
+	HeaderSourceOffset    = "dlq.source.offset"
+	HeaderSourceTimestamp = "dlq.source.timestamp"
+	HeaderError           = "dlq.error"
+	HeaderErrorDetails    = "dlq.error.details"
+	HeaderAttempts        = "dlq.attempts"
+	HeaderFailedAt        = "dlq.failed_at"
+)
+
+// DeadLetterProducer is a DeadLetterQueue which publishes the failed records to a kafka topic,
+// keeping the original key and value so standard kafka tooling can be used to inspect and replay them.
+type DeadLetterProducer struct {
+	client *kgo.Client
+	topic  string
+	logger *zap.Logger
+}
+
+// NewDeadLetterProducer creates a producer which publishes the dead letters to the given topic
+func NewDeadLetterProducer(brokers []string, topic string, logger *zap.Logger) (*DeadLetterProducer, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterProducer{client: client, topic: topic, logger: logger}, nil
+}
+
+// Send publishes the dead letters to the DLQ topic and waits for all of them to be acknowledged
+func (p *DeadLetterProducer) Send(ctx context.Context, deadLetters []models.DeadLetter) error {
+	if len(deadLetters) == 0 {
+		return nil
+	}
+
+	records := make([]*kgo.Record, len(deadLetters))
+	for idx, dl := range deadLetters {
+		records[idx] = &kgo.Record{
+			Key:     dl.Record.Key,
+			Value:   dl.Record.Value,
+			Headers: deadLetterHeaders(dl),
+		}
+	}
+
+	if err := p.client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		return err
+	}
+	p.logger.Info("successfully sent records", zap.String("topic", p.topic), zap.Int("count", len(records)))
+	return nil
+}
+
+// Close flushes the buffered records and closes the producer
+func (p *DeadLetterProducer) Close() {
+	p.client.Close()
+}
+
+func deadLetterHeaders(dl models.DeadLetter) []kgo.RecordHeader {
+	headers := make([]kgo.RecordHeader, 0, len(dl.Record.Headers)+8)
+	for _, header := range dl.Record.Headers {
+		headers = append(headers, kgo.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+
+	headers = append(headers,
+		kgo.RecordHeader{Key: HeaderSourceTopic, Value: []byte(dl.Record.Topic)},
+		kgo.RecordHeader{Key: HeaderSourcePartition, Value: []byte(strconv.FormatInt(int64(dl.Record.Partition), 10))},
+		kgo.RecordHeader{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(dl.Record.Offset, 10))},
+		kgo.RecordHeader{Key: HeaderSourceTimestamp, Value: []byte(dl.Record.Timestamp.Format(time.RFC3339Nano))},
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(dl.Reason)},
+		kgo.RecordHeader{Key: HeaderAttempts, Value: []byte(strconv.Itoa(dl.Attempts))},
+		kgo.RecordHeader{Key: HeaderFailedAt, Value: []byte(dl.FailedAt.Format(time.RFC3339Nano))},
+	)
+	if len(dl.Details) > 0 {
+		if details, err := json.Marshal(dl.Details); err == nil {
+			headers = append(headers, kgo.RecordHeader{Key: HeaderErrorDetails, Value: details})
+		}
+	}
+	return headers
+}
@@ -0,0 +1,433 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	metrics "tx-stream/metrics"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/plugin/kprom"
+	"go.uber.org/zap"
+)
+
+const (
+	testTopic   = "transactions"
+	testTimeout = 20 * time.Second
+)
+
+// errUnavailable is a retryable store failure
+var errUnavailable = errors.NewError("store unavailable")
+
+// harness runs consumers against an in-memory kafka cluster with in-memory fakes of the stores
+type harness struct {
+	t       *testing.T
+	brokers []string
+	repo    *fakeTxRepository
+	dlq     *fakeDeadLetterQueue
+	breaker CircuitBreaker
+	logger  *zap.Logger
+}
+
+func newHarness(t *testing.T, partitions int32) *harness {
+	t.Helper()
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(partitions, testTopic))
+	if err != nil {
+		t.Fatalf("cannot start kafka cluster: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+
+	return &harness{
+		t:       t,
+		brokers: cluster.ListenAddrs(),
+		repo:    newFakeTxRepository(),
+		dlq:     &fakeDeadLetterQueue{},
+		logger:  zap.NewNop(),
+	}
+}
+
+// config returns a consumer config with short timings suited to tests
+func (h *harness) config(group string) *models.ConsumerConfig {
+	return &models.ConsumerConfig{
+		Brokers:               h.brokers,
+		Name:                  group,
+		Topic:                 testTopic,
+		EachPartitionChanSize: 10,
+		QueueHighWatermark:    8,
+		QueueLowWatermark:     2,
+		RecordsPerPoll:        100,
+		Batch:                 models.BatchPolicy{MaxRecords: 100, MaxBytes: 1 << 20, Linger: 10 * time.Millisecond},
+		Parallel:              models.ParallelPolicy{Concurrency: 1, KeyBy: models.KeyByRecordKey},
+		Retry: models.RetryPolicy{
+			MaxAttempts: 3,
+			BaseBackoff: time.Millisecond,
+			MaxBackoff:  5 * time.Millisecond,
+			Jitter:      models.NoJitter,
+		},
+	}
+}
+
+// newConsumer creates a consumer with its own metric registries
+func (h *harness) newConsumer(conf *models.ConsumerConfig, processor TxProcessor) *Consumer {
+	h.t.Helper()
+	kafkaMetrics := kprom.NewMetrics("test", kprom.Registry(prometheus.NewRegistry()))
+	appMetrics := metrics.New("test", prometheus.NewRegistry())
+
+	c, err := NewTxConsumer(conf, h.logger, processor, h.dlq, h.repo, h.breaker, kafkaMetrics, appMetrics)
+	if err != nil {
+		h.t.Fatalf("cannot create consumer: %v", err)
+	}
+	return c
+}
+
+// start polls with the consumer in the background, the returned function stops polling and shuts the
+// consumer down within the drain timeout, returning the shutdown error
+func (h *harness) start(c *Consumer, drainTimeout time.Duration) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	polled := make(chan error, 1)
+	go func() { polled <- c.Poll(ctx) }()
+
+	var once sync.Once
+	var shutdownErr error
+	stop := func() error {
+		once.Do(func() {
+			cancel()
+			if err := <-polled; err != nil {
+				h.t.Errorf("poll returned an error: %v", err)
+			}
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancelShutdown()
+			shutdownErr = c.Shutdown(shutdownCtx)
+		})
+		return shutdownErr
+	}
+	h.t.Cleanup(func() { _ = stop() })
+	return stop
+}
+
+// produce writes the records to their partitions
+func (h *harness) produce(records ...*kgo.Record) {
+	h.t.Helper()
+	client, err := kgo.NewClient(kgo.SeedBrokers(h.brokers...), kgo.RecordPartitioner(kgo.ManualPartitioner()))
+	if err != nil {
+		h.t.Fatalf("cannot create producer: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err = client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		h.t.Fatalf("cannot produce records: %v", err)
+	}
+}
+
+// committed returns the offsets committed by the group per partition
+func (h *harness) committed(group string) map[int32]int64 {
+	h.t.Helper()
+	client, err := kgo.NewClient(kgo.SeedBrokers(h.brokers...))
+	if err != nil {
+		h.t.Fatalf("cannot create admin client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	offsets, err := kadm.NewClient(client).FetchOffsets(ctx, group)
+	if err != nil {
+		h.t.Fatalf("cannot fetch committed offsets: %v", err)
+	}
+
+	committed := make(map[int32]int64)
+	offsets.Each(func(o kadm.OffsetResponse) {
+		if o.Topic == testTopic {
+			committed[o.Partition] = o.At
+		}
+	})
+	return committed
+}
+
+// storedOffsets returns the offsets stored in the repository for the group per partition
+func (h *harness) storedOffsets(group string) map[int32]int64 {
+	h.t.Helper()
+	offsets, err := h.repo.FetchOffsets(context.Background(), group, testTopic)
+	if err != nil {
+		h.t.Fatalf("cannot fetch stored offsets: %v", err)
+	}
+	return offsets
+}
+
+// txRecord builds a record holding a valid transaction for the partition
+func txRecord(partition int32, txID string) *kgo.Record {
+	amount, _ := models.ParseAmount("10.50")
+	value, _ := json.Marshal(models.Transaction{
+		TxID:            txID,
+		UserID:          "user-" + txID,
+		Amount:          amount,
+		Currency:        "INR",
+		TransactionType: "debit",
+		Status:          "completed",
+		Timestamp:       "2025-01-01T00:00:00Z",
+		PaymentMethod:   "card",
+	})
+	return &kgo.Record{Topic: testTopic, Partition: partition, Key: []byte(txID), Value: value}
+}
+
+// eventually fails the test unless the condition holds within the test timeout
+func eventually(t *testing.T, condition func() bool, format string, args ...any) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: "+format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// assigned returns the partitions the consumer is consuming
+func assigned(c *Consumer) []int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var partitions []int32
+	for tp := range c.consumers {
+		partitions = append(partitions, tp.partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions
+}
+
+// paused reports whether fetching the partition is paused by the consumer
+func paused(c *Consumer, partition int32) bool {
+	c.mu.Lock()
+	pc, ok := c.consumers[TopicPartition{testTopic, partition}]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	pc.pauseMu.Lock()
+	defer pc.pauseMu.Unlock()
+	return pc.paused
+}
+
+// fakeTxRepository stores the transactions and offsets in memory, failing the
+// writes with errUnavailable while it is down or failures remain
+type fakeTxRepository struct {
+	mu       sync.Mutex
+	txs      map[string]models.MongoTransaction
+	offsets  map[string]int64
+	failures int
+	down     bool
+	writes   int
+}
+
+func newFakeTxRepository() *fakeTxRepository {
+	return &fakeTxRepository{txs: make(map[string]models.MongoTransaction), offsets: make(map[string]int64)}
+}
+
+// failNext fails the next n writes
+func (r *fakeTxRepository) failNext(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = n
+}
+
+// setDown fails every write until the repository is set up again
+func (r *fakeTxRepository) setDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+// ping fails while the repository is down
+func (r *fakeTxRepository) ping(_ context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errUnavailable
+	}
+	return nil
+}
+
+func (r *fakeTxRepository) UpsertTransaction(ctx context.Context, tx models.MongoTransaction) (models.WriteResult, error) {
+	return r.UpsertTransactions(ctx, []models.MongoTransaction{tx})
+}
+
+func (r *fakeTxRepository) UpsertTransactions(_ context.Context, txs []models.MongoTransaction) (models.WriteResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes++
+	if r.down {
+		return models.WriteResult{}, errUnavailable
+	}
+	if r.failures > 0 {
+		r.failures--
+		return models.WriteResult{}, errUnavailable
+	}
+
+	var result models.WriteResult
+	for _, tx := range txs {
+		if _, ok := r.txs[tx.TxID]; ok {
+			result.Skipped++
+			continue
+		}
+		r.txs[tx.TxID] = tx
+		result.Inserted++
+	}
+	return result, nil
+}
+
+func (r *fakeTxRepository) UpsertTransactionsWithOffset(ctx context.Context, txs []models.MongoTransaction, offset models.PartitionOffset) (models.WriteResult, error) {
+	result, err := r.UpsertTransactions(ctx, txs)
+	if err != nil {
+		return result, err
+	}
+	return result, r.SaveOffset(ctx, offset)
+}
+
+func (r *fakeTxRepository) SaveOffset(_ context.Context, offset models.PartitionOffset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := fmt.Sprintf("%s:%s:%d", offset.Group, offset.Topic, offset.Partition)
+	r.offsets[id] = max(r.offsets[id], offset.Offset)
+	return nil
+}
+
+func (r *fakeTxRepository) FetchOffsets(_ context.Context, group, topic string) (map[int32]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	offsets := make(map[int32]int64)
+	for id, offset := range r.offsets {
+		var partition int32
+		if _, err := fmt.Sscanf(id, group+":"+topic+":%d", &partition); err == nil {
+			offsets[partition] = offset
+		}
+	}
+	return offsets, nil
+}
+
+// count returns the number of stored transactions
+func (r *fakeTxRepository) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.txs)
+}
+
+// writeCount returns the number of write attempts
+func (r *fakeTxRepository) writeCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writes
+}
+
+// fakeDeadLetterQueue keeps the dead letters in memory, failing the next sends while failures remain
+type fakeDeadLetterQueue struct {
+	mu          sync.Mutex
+	deadLetters []models.DeadLetter
+	failures    int
+	sends       int
+}
+
+// failNext fails the next n sends
+func (q *fakeDeadLetterQueue) failNext(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failures = n
+}
+
+func (q *fakeDeadLetterQueue) Send(_ context.Context, deadLetters []models.DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sends++
+	if q.failures > 0 {
+		q.failures--
+		return errors.NewError("dlq unavailable")
+	}
+	q.deadLetters = append(q.deadLetters, deadLetters...)
+	return nil
+}
+
+// sendCount returns the number of send attempts
+func (q *fakeDeadLetterQueue) sendCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sends
+}
+
+// sent returns the dead letters sent so far
+func (q *fakeDeadLetterQueue) sent() []models.DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]models.DeadLetter(nil), q.deadLetters...)
+}
+
+// blockingProcessor blocks every batch until the context is done, failing it as retryable
+type blockingProcessor struct {
+	started chan struct{}
+	once    sync.Once
+}
+
+func newBlockingProcessor() *blockingProcessor {
+	return &blockingProcessor{started: make(chan struct{})}
+}
+
+func (p *blockingProcessor) ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult {
+	p.once.Do(func() { close(p.started) })
+	<-ctx.Done()
+
+	results := make([]models.RecordResult, len(records))
+	for idx, record := range records {
+		results[idx] = models.RecordResult{Record: record, Outcome: models.RetryableFailure, Err: ctx.Err()}
+	}
+	return results
+}
+
+func (p *blockingProcessor) ProcessRecordsWithOffset(ctx context.Context, records []models.Record, _ models.PartitionOffset) []models.RecordResult {
+	return p.ProcessRecords(ctx, records)
+}
+
+// gatedProcessor holds every batch until the gate is opened, then processes it with the wrapped processor
+type gatedProcessor struct {
+	TxProcessor
+	gate chan struct{}
+}
+
+func (p *gatedProcessor) ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult {
+	select {
+	case <-p.gate:
+	case <-ctx.Done():
+	}
+	return p.TxProcessor.ProcessRecords(ctx, records)
+}
+
+// stuckPartitionProcessor blocks the batches of one partition like blockingProcessor, processing the others
+// with the wrapped processor
+type stuckPartitionProcessor struct {
+	TxProcessor
+	partition int32
+	stuck     *blockingProcessor
+}
+
+func (p *stuckPartitionProcessor) ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult {
+	if records[0].Partition == p.partition {
+		return p.stuck.ProcessRecords(ctx, records)
+	}
+	return p.TxProcessor.ProcessRecords(ctx, records)
+}
@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	// Local Packages
+	metrics "tx-stream/metrics"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+// PartitionLag is how far the consumer group is behind on a single partition
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	End       int64  `json:"end"`
+	Lag       int64  `json:"lag"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LagMonitor periodically computes the lag of the consumer group per partition by comparing the committed
+// offsets against the high watermarks, and warns when the lag stays above the threshold for too long.
+type LagMonitor struct {
+	admin   *kadm.Client
+	group   string
+	topic   string
+	config  models.LagConfig
+	metrics *metrics.Metrics
+	logger  *zap.Logger
+
+	mu        sync.RWMutex
+	lags      []PartitionLag
+	overSince map[int32]time.Time
+	warned    map[int32]bool
+}
+
+// NewLagMonitor creates a lag monitor for the group and topic with its own admin client
+func NewLagMonitor(brokers []string, group, topic string, config models.LagConfig, m *metrics.Metrics, logger *zap.Logger) (*LagMonitor, error) {
+	client, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &LagMonitor{
+		admin:     kadm.NewClient(client),
+		group:     group,
+		topic:     topic,
+		config:    config,
+		metrics:   m,
+		logger:    logger,
+		overSince: make(map[int32]time.Time),
+		warned:    make(map[int32]bool),
+	}, nil
+}
+
+// Run computes the lag every interval until the context is canceled
+func (m *LagMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Update(ctx); err != nil && ctx.Err() == nil {
+			m.logger.Error("failed to compute consumer lag", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Update computes the current lag of every partition of the topic
+func (m *LagMonitor) Update(ctx context.Context) error {
+	described, err := m.admin.Lag(ctx, m.group)
+	if err != nil {
+		return err
+	}
+	groupLag, ok := described[m.group]
+	if !ok {
+		return nil
+	}
+	if err = groupLag.Error(); err != nil {
+		return err
+	}
+
+	var lags []PartitionLag
+	for partition, memberLag := range groupLag.Lag[m.topic] {
+		lag := PartitionLag{
+			Topic:     m.topic,
+			Partition: partition,
+			Committed: memberLag.Commit.At,
+			End:       memberLag.End.Offset,
+			Lag:       memberLag.Lag,
+		}
+		if memberLag.Err != nil {
+			lag.Error = memberLag.Err.Error()
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Partition < lags[j].Partition })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lags = lags
+	for _, lag := range lags {
+		if lag.Lag >= 0 {
+			m.metrics.ConsumerLag.WithLabelValues(lag.Topic, metrics.PartitionLabel(lag.Partition)).Set(float64(lag.Lag))
+		}
+		m.checkThreshold(lag)
+	}
+	return nil
+}
+
+// checkThreshold warns once the lag of a partition has been above the threshold for longer than the
+// threshold duration, and logs again when it recovers
+func (m *LagMonitor) checkThreshold(lag PartitionLag) {
+	if lag.Lag <= m.config.Threshold {
+		if m.warned[lag.Partition] {
+			m.logger.Info("consumer lag recovered", zap.String("topic", lag.Topic),
+				zap.Int32("partition", lag.Partition), zap.Int64("lag", lag.Lag))
+		}
+		delete(m.overSince, lag.Partition)
+		delete(m.warned, lag.Partition)
+		return
+	}
+
+	since, ok := m.overSince[lag.Partition]
+	if !ok {
+		m.overSince[lag.Partition] = time.Now()
+		return
+	}
+	if !m.warned[lag.Partition] && time.Since(since) >= m.config.ThresholdDuration {
+		m.warned[lag.Partition] = true
+		m.logger.Warn("consumer lag above threshold", zap.String("topic", lag.Topic),
+			zap.Int32("partition", lag.Partition), zap.Int64("lag", lag.Lag),
+			zap.Int64("threshold", m.config.Threshold), zap.Duration("for", time.Since(since)))
+	}
+}
+
+// Lags returns the lag of every partition as of the last update
+func (m *LagMonitor) Lags() []PartitionLag {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]PartitionLag(nil), m.lags...)
+}
+
+// Handler serves the lag of every partition as of the last update
+func (m *LagMonitor) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"group":      m.group,
+			"partitions": m.Lags(),
+		})
+	})
+}
+
+// Close closes the admin client
+func (m *LagMonitor) Close() {
+	m.admin.Close()
+}
@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+
+	// Local Packages
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// orderingKey returns the key whose records must be processed in order. The user_id is read from JSON values,
+// values which are not JSON or have no user_id share the empty key and are all processed by the same worker.
+func orderingKey(keyBy models.KeyBy, record *kgo.Record) []byte {
+	if keyBy != models.KeyByUserID {
+		return record.Key
+	}
+
+	var tx struct {
+		UserID string `json:"user_id"`
+	}
+	_ = json.Unmarshal(record.Value, &tx)
+	return []byte(tx.UserID)
+}
+
+// workerPool processes the batches of a partition in parallel, routing each record to a worker by its
+// ordering key so records with the same key are processed in order by the same worker.
+type workerPool struct {
+	pc      *PartitionConsumer
+	queues  []chan []*kgo.Record
+	tracker *offsetTracker
+	wg      sync.WaitGroup
+}
+
+// newWorkerPool starts a worker for each of the consumer's concurrency
+func newWorkerPool(ctx context.Context, pc *PartitionConsumer) *workerPool {
+	wp := &workerPool{
+		pc:      pc,
+		queues:  make([]chan []*kgo.Record, pc.parallel.Concurrency),
+		tracker: &offsetTracker{done: make(map[int64]bool), mark: pc.client.MarkCommitRecords},
+	}
+
+	for idx := range wp.queues {
+		wp.queues[idx] = make(chan []*kgo.Record, 1)
+		wp.wg.Add(1)
+		go wp.work(ctx, wp.queues[idx])
+	}
+	return wp
+}
+
+// dispatch splits the batch by ordering key and hands the parts to the workers,
+// blocking while a worker is still busy with its previous parts.
+func (wp *workerPool) dispatch(batch []*kgo.Record) {
+	wp.tracker.add(batch)
+
+	parts := make([][]*kgo.Record, len(wp.queues))
+	for _, record := range batch {
+		h := fnv.New32a()
+		_, _ = h.Write(orderingKey(wp.pc.parallel.KeyBy, record))
+		worker := h.Sum32() % uint32(len(wp.queues))
+		parts[worker] = append(parts[worker], record)
+	}
+
+	for worker, part := range parts {
+		if len(part) > 0 {
+			wp.queues[worker] <- part
+		}
+	}
+}
+
+// work processes the parts handed to the worker until its queue is closed
+func (wp *workerPool) work(ctx context.Context, queue <-chan []*kgo.Record) {
+	defer wp.wg.Done()
+	for part := range queue {
+		if wp.pc.handleBatch(ctx, part) {
+			wp.tracker.complete(part)
+		}
+	}
+}
+
+// close waits for the workers to process the dispatched parts
+func (wp *workerPool) close() {
+	for _, queue := range wp.queues {
+		close(queue)
+	}
+	wp.wg.Wait()
+}
+
+// offsetTracker marks the records only up to the lowest contiguous completed offset, so a record is never
+// committed while an earlier record of the partition is still being processed by another worker.
+type offsetTracker struct {
+	mu       sync.Mutex
+	inflight []*kgo.Record // in offset order
+	done     map[int64]bool
+	mark     func(rs ...*kgo.Record)
+}
+
+// add tracks the dispatched records
+func (t *offsetTracker) add(records []*kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inflight = append(t.inflight, records...)
+}
+
+// complete records the records as processed and marks the highest contiguous completed record
+func (t *offsetTracker) complete(records []*kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, record := range records {
+		t.done[record.Offset] = true
+	}
+
+	var last *kgo.Record
+	for len(t.inflight) > 0 && t.done[t.inflight[0].Offset] {
+		last = t.inflight[0]
+		delete(t.done, last.Offset)
+		t.inflight = t.inflight[1:]
+	}
+	if last != nil {
+		t.mark(last)
+	}
+}
@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	// Local Packages
+	models "tx-stream/models"
+	txpsr "tx-stream/services/processors"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		inflight []int64   // offsets dispatched, in order
+		complete [][]int64 // offsets of each completed part, in completion order
+		want     []int64   // offsets marked after each completion, -1 when nothing is marked
+	}{
+		{
+			name:     "in order",
+			inflight: []int64{0, 1, 2},
+			complete: [][]int64{{0, 1}, {2}},
+			want:     []int64{1, 2},
+		},
+		{
+			name:     "out of order",
+			inflight: []int64{0, 1, 2, 3},
+			complete: [][]int64{{2, 3}, {0}, {1}},
+			want:     []int64{-1, 0, 3},
+		},
+		{
+			name:     "interleaved parts",
+			inflight: []int64{0, 1, 2, 3, 4, 5},
+			complete: [][]int64{{1, 3, 5}, {0, 2}, {4}},
+			want:     []int64{-1, 3, 5},
+		},
+		{
+			name:     "gaps in offsets",
+			inflight: []int64{3, 7, 8, 12},
+			complete: [][]int64{{7}, {3}, {12}, {8}},
+			want:     []int64{-1, 7, -1, 12},
+		},
+		{
+			// the part of the revoked partition is never completed, so nothing after it is marked
+			name:     "revoked partition",
+			inflight: []int64{0, 1, 2, 3},
+			complete: [][]int64{{0}, {2, 3}},
+			want:     []int64{0, -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := make(map[int64]*kgo.Record, len(tt.inflight))
+			inflight := make([]*kgo.Record, len(tt.inflight))
+			for idx, offset := range tt.inflight {
+				records[offset] = &kgo.Record{Topic: testTopic, Offset: offset}
+				inflight[idx] = records[offset]
+			}
+
+			var marked []int64
+			tracker := &offsetTracker{done: make(map[int64]bool), mark: func(rs ...*kgo.Record) {
+				for _, r := range rs {
+					marked = append(marked, r.Offset)
+				}
+			}}
+			tracker.add(inflight)
+
+			for idx, offsets := range tt.complete {
+				part := make([]*kgo.Record, len(offsets))
+				for i, offset := range offsets {
+					part[i] = records[offset]
+				}
+				before := len(marked)
+				tracker.complete(part)
+
+				got := int64(-1)
+				if len(marked) > before {
+					got = marked[len(marked)-1]
+				}
+				if got != tt.want[idx] {
+					t.Errorf("after completing %v marked %d, want %d", offsets, got, tt.want[idx])
+				}
+			}
+			if !slices.IsSorted(marked) {
+				t.Errorf("marked offsets %v went backwards", marked)
+			}
+		})
+	}
+}
+
+func TestConsumerProcessesInParallel(t *testing.T) {
+	h := newHarness(t, 1)
+	var records []*kgo.Record
+	for idx := range 20 {
+		records = append(records, txRecord(0, fmt.Sprintf("tx-%d", idx)))
+	}
+	h.produce(records...)
+
+	conf := h.config("parallel")
+	conf.Parallel = models.ParallelPolicy{Concurrency: 4, KeyBy: models.KeyByRecordKey}
+	c := h.newConsumer(conf, txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return h.repo.count() == 20 }, "20 stored transactions, got %d", h.repo.count())
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if committed := h.committed("parallel"); committed[0] != 20 {
+		t.Errorf("committed offset = %d, want 20", committed[0])
+	}
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records, want none", len(sent))
+	}
+}
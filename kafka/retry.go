@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"math/rand"
+	"time"
+
+	// Local Packages
+	models "tx-stream/models"
+)
+
+// Backoff returns how long to wait after the given attempt (starting at 1) before retrying.
+// The backoff doubles on every attempt starting at BaseBackoff and is capped at MaxBackoff.
+func Backoff(policy models.RetryPolicy, attempt int) time.Duration {
+	backoff := policy.MaxBackoff
+	if shift := attempt - 1; shift < 32 {
+		if exp := policy.BaseBackoff << shift; exp > 0 && exp < policy.MaxBackoff {
+			backoff = exp
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	switch policy.Jitter {
+	case models.FullJitter:
+		return time.Duration(rand.Int63n(int64(backoff) + 1))
+	case models.EqualJitter:
+		half := backoff / 2
+		return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+	default:
+		return backoff
+	}
+}
+
+// Sleep waits for the given duration, returning early with the context's error if it is done first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -3,13 +3,14 @@ package kafka
 import (
 	// Go Internal Packages
 	"context"
-	"errors"
 	"fmt"
-	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// Local Packages
+	errors "tx-stream/errors"
+	metrics "tx-stream/metrics"
 	models "tx-stream/models"
 	utils "tx-stream/utils"
 
@@ -25,26 +26,94 @@ const (
 	PartitionLostLog     = "%s: Partitions Lost [%s]"
 	ErrorPollingLog      = "%s: Error while polling records [%d]"
 	KillingConsumerLog   = "%s: Killing Consumers [%s]"
+	ResumingOffsetsLog   = "%s: Resuming From Stored Offsets [%s]"
+	PausedFetchLog       = "%s: Paused Fetching Partition [%d], %d batches queued"
+	ResumedFetchLog      = "%s: Resumed Fetching Partition [%d], %d batches queued"
+	BreakerOpenLog       = "%s: Circuit Breaker Open, Paused Fetching Topic"
+	BreakerClosedLog     = "%s: Circuit Breaker Closed, Resumed Fetching Topic"
 )
 
+// shutdownCommitTimeout bounds committing the marked offsets once the consumers are drained, which still
+// happens when the drain timed out
+const shutdownCommitTimeout = 5 * time.Second
+
+// TxProcessor processes a batch of records and reports the outcome of each record, in the same order.
 type TxProcessor interface {
-	ProcessRecords(ctx context.Context, records []models.Record) error
+	ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult
+	ProcessRecordsWithOffset(ctx context.Context, records []models.Record, offset models.PartitionOffset) []models.RecordResult
+}
+
+// OffsetStore stores the consumed offsets outside of kafka, used in exactly-once mode.
+type OffsetStore interface {
+	FetchOffsets(ctx context.Context, group, topic string) (map[int32]int64, error)
+	SaveOffset(ctx context.Context, offset models.PartitionOffset) error
 }
 
 type DeadLetterQueue interface {
-	Send(ctx context.Context, records []models.Record) error
+	Send(ctx context.Context, deadLetters []models.DeadLetter) error
+}
+
+// CircuitBreaker trips while the store is unavailable, consumption is paused and
+// in-flight batches wait for it to close instead of being dead-lettered.
+type CircuitBreaker interface {
+	Open() bool
+	Wait(ctx context.Context) error
+	OnStateChange(fn func(open bool))
 }
 
 type PartitionConsumer struct {
-	client    *kgo.Client
-	topic     string
-	partition int32
-	processor TxProcessor
-	dlq       DeadLetterQueue
-	recs      chan kgo.FetchTopicPartition
-	quit      chan bool
-	done      chan bool
-	logger    *zap.Logger
+	client      *kgo.Client
+	group       string
+	topic       string
+	partition   int32
+	processor   TxProcessor
+	dlq         DeadLetterQueue
+	offsets     OffsetStore
+	breaker     CircuitBreaker
+	exactlyOnce bool
+	batch       models.BatchPolicy
+	parallel    models.ParallelPolicy
+	retry       models.RetryPolicy
+	metrics     *metrics.Metrics
+	progress    *Progress
+	recs        chan kgo.FetchTopicPartition
+	quit        chan bool
+	done        chan bool
+	logger      *zap.Logger
+
+	// waitCtx is canceled once the partition is revoked, lost or shut down, ending the waits for the DLQ and
+	// the circuit breaker so a rebalance never blocks on an unavailable store. The batch is then redelivered.
+	waitCtx     context.Context
+	stopWaiting context.CancelFunc
+
+	// fetching is paused once highWatermark batches are queued and resumed at lowWatermark
+	highWatermark int
+	lowWatermark  int
+	pauseMu       sync.Mutex
+	paused        bool
+}
+
+// Progress tracks when the partition consumers last processed a batch and last hit a retryable failure
+type Progress struct {
+	lastSuccess atomic.Int64
+	lastFailure atomic.Int64
+}
+
+// NewProgress creates a progress which counts the creation time as the last success
+func NewProgress() *Progress {
+	p := &Progress{}
+	p.lastSuccess.Store(time.Now().UnixNano())
+	return p
+}
+
+func (p *Progress) succeeded() { p.lastSuccess.Store(time.Now().UnixNano()) }
+func (p *Progress) failed()    { p.lastFailure.Store(time.Now().UnixNano()) }
+
+// Stalled reports whether batches have been failing without a single success for longer than maxAge.
+// An idle topic is not stalled, as nothing is failing.
+func (p *Progress) Stalled(maxAge time.Duration) bool {
+	lastSuccess, lastFailure := p.lastSuccess.Load(), p.lastFailure.Load()
+	return lastFailure > lastSuccess && time.Since(time.Unix(0, lastSuccess)) > maxAge
 }
 
 type TopicPartition struct {
@@ -56,21 +125,47 @@ type Consumer struct {
 	client    *kgo.Client
 	config    *models.ConsumerConfig
 	processor TxProcessor
+	mu        sync.Mutex
 	consumers map[TopicPartition]*PartitionConsumer
 	logger    *zap.Logger
 	dlq       DeadLetterQueue
+	offsets   OffsetStore
+	breaker   CircuitBreaker
+	metrics   *metrics.Metrics
+	progress  *Progress
+
+	// processing outlives the polling context so in-flight batches can finish during shutdown
+	processCtx       context.Context
+	cancelProcessing context.CancelFunc
 }
 
-// NewTxConsumer creates a new consumer and starts a goroutine for each partition to consume the records fetched
+// NewTxConsumer creates a new consumer and starts a goroutine for each partition to consume the records fetched.
+// The circuit breaker is optional, without one batches are dead-lettered once their retries are exhausted.
 // PS: Must call Poll to start consuming the records
-func NewTxConsumer(conf *models.ConsumerConfig, logger *zap.Logger, processor TxProcessor, dlq DeadLetterQueue, m *kprom.Metrics) (*Consumer, error) {
+func NewTxConsumer(conf *models.ConsumerConfig, logger *zap.Logger, processor TxProcessor, dlq DeadLetterQueue, offsets OffsetStore,
+	breaker CircuitBreaker, m *kprom.Metrics, appMetrics *metrics.Metrics) (*Consumer, error) {
+	if conf.ExactlyOnce && offsets == nil {
+		return nil, errors.NewError("exactly-once mode requires an offset store")
+	}
+	if !conf.Parallel.KeyBy.IsValid() {
+		return nil, errors.NewError(fmt.Sprintf("unknown ordering key %q", conf.Parallel.KeyBy))
+	}
+	if !conf.Retry.Jitter.IsValid() {
+		return nil, errors.NewError(fmt.Sprintf("unknown retry jitter %q", conf.Retry.Jitter))
+	}
+
 	c := &Consumer{
 		config:    conf,
 		processor: processor,
 		consumers: make(map[TopicPartition]*PartitionConsumer),
 		logger:    logger,
 		dlq:       dlq,
+		offsets:   offsets,
+		breaker:   breaker,
+		metrics:   appMetrics,
+		progress:  NewProgress(),
 	}
+	c.processCtx, c.cancelProcessing = context.WithCancel(context.Background())
 
 	opts := []kgo.Opt{
 		kgo.SeedBrokers(conf.Brokers...),
@@ -91,29 +186,98 @@ func NewTxConsumer(conf *models.ConsumerConfig, logger *zap.Logger, processor Tx
 	}
 
 	c.client = client
+	if breaker != nil {
+		breaker.OnStateChange(c.breakerStateChanged)
+	}
 	return c, nil
 }
 
+// breakerStateChanged pauses fetching the topic while the circuit breaker is open. Topic pauses are
+// independent of the partition pauses used for backpressure and outlive rebalances, so partitions
+// assigned while the breaker is open stay paused as well.
+func (c *Consumer) breakerStateChanged(open bool) {
+	if open {
+		c.client.PauseFetchTopics(c.config.Topic)
+		c.logger.Warn(fmt.Sprintf(BreakerOpenLog, c.config.Topic))
+		return
+	}
+	c.client.ResumeFetchTopics(c.config.Topic)
+	c.logger.Info(fmt.Sprintf(BreakerClosedLog, c.config.Topic))
+}
+
 // Assigned creates a new consumer for each assigned partition and starts a goroutine to consume the records.
+// In exactly-once mode the partitions resume from the offsets stored in the offset store.
 func (c *Consumer) Assigned(ctx context.Context, client *kgo.Client, assigned map[string][]int32) {
+	if c.config.ExactlyOnce {
+		c.ResumeFromStoredOffsets(ctx, client, assigned)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for topic, partitions := range assigned {
 		c.logger.Info(fmt.Sprintf(PartitionAssignedLog, topic, utils.JoinInt32Slice(partitions)))
 		for _, partition := range partitions {
 			pc := &PartitionConsumer{
-				client:    client,
-				topic:     topic,
-				partition: partition,
-				processor: c.processor,
-				dlq:       c.dlq,
-				recs:      make(chan kgo.FetchTopicPartition, c.config.EachPartitionChanSize),
-				quit:      make(chan bool),
-				done:      make(chan bool),
-				logger:    c.logger,
+				client:      client,
+				group:       c.config.Name,
+				topic:       topic,
+				partition:   partition,
+				processor:   c.processor,
+				dlq:         c.dlq,
+				offsets:     c.offsets,
+				breaker:     c.breaker,
+				exactlyOnce: c.config.ExactlyOnce,
+				batch:       c.config.Batch,
+				parallel:    c.config.Parallel,
+				retry:       c.config.Retry,
+				metrics:     c.metrics,
+				progress:    c.progress,
+				recs:        make(chan kgo.FetchTopicPartition, c.config.EachPartitionChanSize),
+				quit:        make(chan bool),
+				done:        make(chan bool),
+				logger:      c.logger,
+
+				highWatermark: c.config.QueueHighWatermark,
+				lowWatermark:  c.config.QueueLowWatermark,
 			}
+			pc.waitCtx, pc.stopWaiting = context.WithCancel(c.processCtx)
 			c.consumers[TopicPartition{topic, partition}] = pc
-			go pc.Consume(ctx)
+			go pc.Consume(c.processCtx)
+		}
+	}
+}
+
+// ResumeFromStoredOffsets sets the fetch offsets of the assigned partitions to the offsets stored
+// in the offset store. Partitions without a stored offset fall back to the group's committed offset.
+func (c *Consumer) ResumeFromStoredOffsets(ctx context.Context, client *kgo.Client, assigned map[string][]int32) {
+	setOffsets := make(map[string]map[int32]kgo.EpochOffset)
+	for topic, partitions := range assigned {
+		stored, err := c.offsets.FetchOffsets(ctx, c.config.Name, topic)
+		if err != nil {
+			c.logger.Error("failed to fetch stored offsets, using committed offsets", zap.String("topic", topic), zap.Error(err))
+			continue
+		}
+
+		var resumed []int32
+		for _, partition := range partitions {
+			offset, ok := stored[partition]
+			if !ok {
+				continue
+			}
+			if setOffsets[topic] == nil {
+				setOffsets[topic] = make(map[int32]kgo.EpochOffset)
+			}
+			setOffsets[topic][partition] = kgo.EpochOffset{Epoch: -1, Offset: offset}
+			resumed = append(resumed, partition)
+		}
+		if len(resumed) > 0 {
+			c.logger.Info(fmt.Sprintf(ResumingOffsetsLog, topic, utils.JoinInt32Slice(resumed)))
 		}
 	}
+
+	if len(setOffsets) > 0 {
+		client.SetOffsets(setOffsets)
+	}
 }
 
 // Revoked commits the marked offsets and kills the consumers.
@@ -137,86 +301,454 @@ func (c *Consumer) Lost(ctx context.Context, client *kgo.Client, lost map[string
 	c.KillConsumers(lost)
 }
 
-// KillConsumers kills the consumers for the lost partitions and closes the consumer goroutine.
+// KillConsumers kills the consumers for the lost partitions and waits for them to finish their in-flight batch.
 func (c *Consumer) KillConsumers(lost map[string][]int32) {
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	<-c.killConsumers(lost)
+}
 
-	for topic, partitions := range lost {
-		c.logger.Info(fmt.Sprintf(KillingConsumerLog, topic, utils.JoinInt32Slice(partitions)))
-		for _, partition := range partitions {
+// killConsumers signals the consumers for the given partitions to quit, the returned
+// channel is closed once all of them have finished their in-flight batch.
+func (c *Consumer) killConsumers(partitions map[string][]int32) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for topic, ps := range partitions {
+		c.logger.Info(fmt.Sprintf(KillingConsumerLog, topic, utils.JoinInt32Slice(ps)))
+		for _, partition := range ps {
 			tp := TopicPartition{topic, partition}
-			pc := c.consumers[tp]
-			close(c.consumers[tp].quit)
+			pc, ok := c.consumers[tp]
+			if !ok {
+				continue
+			}
+			pc.stopWaiting()
+			close(pc.quit)
 			delete(c.consumers, tp)
 			wg.Add(1)
-			go func() { <-pc.done; wg.Done() }()
+			go func() { <-pc.done; pc.release(); wg.Done() }()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	return done
+}
+
+// Shutdown stops the partition consumers, letting each finish its in-flight batch, commits the marked
+// offsets and closes the client. If the context is done before the consumers finish, their in-flight
+// batches are aborted without being marked, so they are redelivered after the next assignment.
+// PS: Must be called after Poll has returned
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	assigned := make(map[string][]int32)
+	for tp := range c.consumers {
+		assigned[tp.topic] = append(assigned[tp.topic], tp.partition)
+	}
+	c.mu.Unlock()
+
+	var drainErr error
+	drained := c.killConsumers(assigned)
+	select {
+	case <-drained:
+		c.logger.Info("partition consumers drained")
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("timed out draining partition consumers: %w", ctx.Err())
+		c.logger.Warn("timed out draining partition consumers, aborting in-flight batches")
+		c.cancelProcessing()
+		<-drained
+	}
+	c.cancelProcessing()
+
+	// the drain may have used up the context, the offsets marked before it are committed regardless
+	commitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownCommitTimeout)
+	defer cancel()
+	commitErr := c.client.CommitMarkedOffsets(commitCtx)
+	if commitErr != nil {
+		c.logger.Error("failed to commit marked offsets", zap.Error(commitErr))
+	}
+
+	c.Close()
+	if drainErr != nil {
+		return drainErr
+	}
+	return commitErr
+}
+
+// CheckGroup reports an error unless the client is a member of the consumer group with assigned partitions
+func (c *Consumer) CheckGroup(_ context.Context) error {
+	if memberID, _ := c.client.GroupMetadata(); memberID == "" {
+		return errors.NewError("not a member of the consumer group")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.consumers) == 0 {
+		return errors.NewError("no partitions assigned")
+	}
+	return nil
+}
+
+// CheckProgress returns a check reporting an error when batches have been failing for longer than maxAge
+func (c *Consumer) CheckProgress(maxAge time.Duration) func(ctx context.Context) error {
+	return func(_ context.Context) error {
+		if c.progress.Stalled(maxAge) {
+			return fmt.Errorf("no successful batch in the last %s", maxAge)
 		}
+		return nil
 	}
 }
 
 // Close closes the client.
 func (c *Consumer) Close() {
 	// on client close PartitionsRevoked is called where we commit the marked offsets
-	c.client.Close()
+	c.client.CloseAllowingRebalance()
 }
 
-// Consume consumes the records from the partition. This will be called in a separate
-// goroutine for each assigned partition. Marks the records after processing.
+// Consume consumes the records from the partition. This will be called in a separate goroutine for each
+// assigned partition. Records are accumulated across fetches until the batch policy's max records, max bytes
+// or linger is reached, then processed and marked. The accumulated records are processed before quitting.
+// With a concurrency above one the batches are handed to a pool of workers, ordered by key.
 func (pc *PartitionConsumer) Consume(ctx context.Context) {
 	defer close(pc.done)
+
+	var pool *workerPool
+	if pc.parallel.Concurrency > 1 {
+		pool = newWorkerPool(ctx, pc)
+		defer pool.close()
+	}
+
+	var pending []*kgo.Record
+	var pendingBytes int
+	linger := time.NewTimer(pc.batch.Linger)
+	linger.Stop()
+	var lingerC <-chan time.Time
+
+	flush := func() bool {
+		linger.Stop()
+		lingerC = nil
+		records := pending
+		pending, pendingBytes = nil, 0
+		if pool != nil {
+			pool.dispatch(records)
+			return true
+		}
+		return pc.processBatch(ctx, records)
+	}
+
 	for {
 		select {
 		case <-pc.quit:
+			if len(pending) > 0 {
+				flush()
+			}
 			return
+		case <-lingerC:
+			if !flush() {
+				return
+			}
 		case p := <-pc.recs:
-			records := make([]models.Record, len(p.Records), len(p.Records))
-			for idx, record := range p.Records {
-				records[idx] = models.Record{
-					Key:   record.Key,
-					Value: record.Value,
-					Topic: record.Topic,
+			pc.dequeued()
+			for _, record := range p.Records {
+				if len(pending) == 0 {
+					linger.Reset(pc.batch.Linger)
+					lingerC = linger.C
 				}
-			}
-
-			if err := pc.ProcessRecordsWithRetry(ctx, records); err != nil {
-				pc.logger.Error("processing failed after retries, sending to DLQ", zap.Error(err))
-				if err = pc.dlq.Send(ctx, records); err != nil {
-					pc.logger.Error("failed to send records to DLQ", zap.Error(err))
+				pending = append(pending, record)
+				pendingBytes += len(record.Key) + len(record.Value)
+				if len(pending) >= pc.batch.MaxRecords || pendingBytes >= pc.batch.MaxBytes {
+					if !flush() {
+						return
+					}
 				}
 			}
-			pc.client.MarkCommitRecords(p.Records...)
 		}
 	}
 }
 
-func (pc *PartitionConsumer) ProcessRecordsWithRetry(ctx context.Context, records []models.Record) error {
-	if len(records) == 0 {
-		return nil
+// processBatch processes the records and marks all of them. Returns false if processing
+// was interrupted, in which case nothing is marked and the records will be redelivered.
+func (pc *PartitionConsumer) processBatch(ctx context.Context, batch []*kgo.Record) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	if !pc.handleBatch(ctx, batch) {
+		return false
+	}
+	pc.client.MarkCommitRecords(batch...)
+	return true
+}
+
+// handleBatch processes the records and dead-letters the failed ones, returning false if processing was interrupted
+func (pc *PartitionConsumer) handleBatch(ctx context.Context, batch []*kgo.Record) bool {
+	records := make([]models.Record, len(batch))
+	for idx, record := range batch {
+		records[idx] = NewRecord(record)
 	}
-	var err error
-	for attempt := 1; attempt <= 3; attempt++ {
-		err = pc.processor.ProcessRecords(ctx, records)
+
+	offset := models.PartitionOffset{
+		Group:     pc.group,
+		Topic:     pc.topic,
+		Partition: pc.partition,
+		Offset:    batch[len(batch)-1].Offset + 1,
+	}
+
+	deadLetters, err := pc.ProcessRecordsWithRetry(ctx, records, offset)
+	if err != nil {
+		pc.logger.Warn("processing interrupted, records will be redelivered", zap.Error(err))
+		return false
+	}
+
+	if len(deadLetters) > 0 {
+		pc.logger.Error("records failed processing, sending to DLQ", zap.Int("count", len(deadLetters)))
+		if err := pc.sendDeadLetters(pc.waitCtx, deadLetters); err != nil {
+			pc.logger.Warn("dead-lettering interrupted, records will be redelivered", zap.Error(err))
+			return false
+		}
+		pc.metrics.RecordsDeadLettered.WithLabelValues(pc.labels()...).Add(float64(len(deadLetters)))
+		if pc.exactlyOnce {
+			if err := pc.offsets.SaveOffset(ctx, offset); err != nil {
+				pc.logger.Error("failed to store offset after DLQ", zap.Error(err))
+			}
+		}
+	}
+	return true
+}
+
+// sendDeadLetters sends the dead letters to the DLQ, retrying with the backoff of the retry policy until they are
+// sent, so the records are never marked before being dead-lettered. Returns the context's error if it is done first.
+func (pc *PartitionConsumer) sendDeadLetters(ctx context.Context, deadLetters []models.DeadLetter) error {
+	for attempt := 1; ; attempt++ {
+		err := pc.dlq.Send(ctx, deadLetters)
 		if err == nil {
-			pc.logger.Info("successfully processed records", zap.Int("count", len(records)))
 			return nil
 		}
-		pc.logger.Warn("processing failed, retrying...", zap.Int("attempt", attempt), zap.Error(err))
-		jitter := time.Duration(rand.Int63n(int64(time.Second)) * (1 << attempt)) // 1s, 2s-4s, 4s-8s, 8s-16s
-		time.Sleep(jitter)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		backoff := Backoff(pc.retry, attempt)
+		pc.logger.Error("failed to send records to DLQ, retrying...", zap.Int("attempt", attempt),
+			zap.Int("count", len(deadLetters)), zap.Duration("backoff", backoff), zap.Error(err))
+		if err = Sleep(ctx, backoff); err != nil {
+			return err
+		}
 	}
-	return err
 }
 
-func (c *Consumer) Poll(ctx context.Context) error {
-	defer c.Close()
+// ProcessRecordsWithRetry processes the records, retrying only the records that failed with a retryable
+// error as per the retry policy. Returns the records that failed permanently or exhausted their retries,
+// to be dead-lettered. Returns the context's error if it is done, in which case nothing is dead-lettered.
+// In exactly-once mode the offset is stored atomically with the processed records. While the circuit breaker
+// is open the failed records wait for it to close and are then retried from the first attempt.
+func (pc *PartitionConsumer) ProcessRecordsWithRetry(ctx context.Context, records []models.Record, offset models.PartitionOffset) ([]models.DeadLetter, error) {
+	start := time.Now()
+	pc.metrics.BatchSize.WithLabelValues(pc.topic).Observe(float64(len(records)))
+
+	retryCtx, cancel := pc.retryContext(ctx)
+	defer func() { cancel() }()
+
+	var deadLetters []models.DeadLetter
+	pending := records
+	for attempt := 1; ; attempt++ {
+		var retryable []models.RecordResult
+		for _, result := range pc.process(retryCtx, pending, resumeOffset(offset, deadLetters)) {
+			switch {
+			case result.Outcome == models.Succeeded:
+			case result.Outcome == models.PermanentFailure || errors.IsPermanent(result.Err):
+				deadLetters = append(deadLetters, NewDeadLetter(result, attempt))
+			default:
+				retryable = append(retryable, result)
+			}
+		}
+
+		if len(retryable) == 0 {
+			break
+		}
+		pc.progress.failed()
+
+		// the records are not marked, so they are redelivered to whoever consumes the partition next
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		pending = make([]models.Record, len(retryable))
+		for idx, result := range retryable {
+			pending[idx] = result.Record
+		}
+
+		// the store is unavailable, wait for it rather than burning the retries and dead-lettering the records
+		if pc.breaker != nil && pc.breaker.Open() {
+			pc.logger.Warn("circuit breaker open, waiting to retry", zap.Int("count", len(pending)))
+			if err := pc.breaker.Wait(pc.waitCtx); err != nil {
+				return nil, err
+			}
+			cancel()
+			retryCtx, cancel = pc.retryContext(ctx)
+			attempt = 0
+			continue
+		}
+
+		exhausted := attempt >= pc.retry.MaxAttempts
+		if !exhausted {
+			backoff := Backoff(pc.retry, attempt)
+			pc.logger.Warn("processing failed, retrying...", zap.Int("attempt", attempt), zap.Int("count", len(retryable)),
+				zap.Duration("backoff", backoff), zap.Error(retryable[0].Err))
+			if err := Sleep(retryCtx, backoff); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				exhausted = true // retry deadline exceeded
+			}
+		}
+
+		if exhausted {
+			for _, result := range retryable {
+				deadLetters = append(deadLetters, NewDeadLetter(result, attempt))
+			}
+			break
+		}
+
+		pc.metrics.Retries.WithLabelValues(pc.labels()...).Add(float64(len(retryable)))
+	}
+
+	pc.metrics.ProcessingLatency.WithLabelValues(pc.topic).Observe(time.Since(start).Seconds())
+	pc.metrics.RecordsFailed.WithLabelValues(pc.labels()...).Add(float64(len(deadLetters)))
+	if processed := len(records) - len(deadLetters); processed > 0 {
+		pc.metrics.RecordsProcessed.WithLabelValues(pc.labels()...).Add(float64(processed))
+		pc.progress.succeeded()
+		pc.logger.Info("successfully processed records", zap.Int("count", processed))
+	}
+	return deadLetters, nil
+}
 
+// resumeOffset returns the offset stored along with the processed records, which is never past a record still
+// to be dead-lettered. The end of the batch is stored once the dead letters are sent.
+func resumeOffset(offset models.PartitionOffset, deadLetters []models.DeadLetter) models.PartitionOffset {
+	for _, dl := range deadLetters {
+		offset.Offset = min(offset.Offset, dl.Record.Offset)
+	}
+	return offset
+}
+
+// retryContext bounds the retries of a batch by the retry deadline, if any
+func (pc *PartitionConsumer) retryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if pc.retry.Deadline > 0 {
+		return context.WithTimeout(ctx, pc.retry.Deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// enqueued pauses fetching the partition once its queue reaches the high watermark, so a slow partition
+// does not block the poll loop for every other partition. Pausing drops the buffered fetches of the
+// partition, which are fetched again once it is resumed. The depth is read under the lock, as a depth
+// read before a concurrent dequeue could otherwise pause the partition after its queue has drained.
+func (pc *PartitionConsumer) enqueued() {
+	pc.pauseMu.Lock()
+	defer pc.pauseMu.Unlock()
+
+	depth := len(pc.recs)
+	pc.metrics.QueueDepth.WithLabelValues(pc.labels()...).Set(float64(depth))
+	if pc.paused || depth < pc.highWatermark {
+		return
+	}
+	pc.paused = true
+	pc.client.PauseFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+	pc.metrics.PartitionPaused.WithLabelValues(pc.labels()...).Set(1)
+	pc.logger.Warn(fmt.Sprintf(PausedFetchLog, pc.topic, pc.partition, depth))
+}
+
+// dequeued resumes fetching the partition once its queue drains to the low watermark
+func (pc *PartitionConsumer) dequeued() {
+	pc.pauseMu.Lock()
+	defer pc.pauseMu.Unlock()
+
+	depth := len(pc.recs)
+	pc.metrics.QueueDepth.WithLabelValues(pc.labels()...).Set(float64(depth))
+	if !pc.paused || depth > pc.lowWatermark {
+		return
+	}
+	pc.paused = false
+	pc.client.ResumeFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+	pc.metrics.PartitionPaused.WithLabelValues(pc.labels()...).Set(0)
+	pc.logger.Info(fmt.Sprintf(ResumedFetchLog, pc.topic, pc.partition, depth))
+}
+
+// release resumes fetching the partition if it was paused, as pauses outlive the assignment and
+// would otherwise stall the partition once reassigned, and drops its queue metrics.
+func (pc *PartitionConsumer) release() {
+	pc.pauseMu.Lock()
+	defer pc.pauseMu.Unlock()
+	if pc.paused {
+		pc.paused = false
+		pc.client.ResumeFetchPartitions(map[string][]int32{pc.topic: {pc.partition}})
+	}
+	pc.metrics.QueueDepth.DeleteLabelValues(pc.labels()...)
+	pc.metrics.PartitionPaused.DeleteLabelValues(pc.labels()...)
+}
+
+// labels returns the topic and partition metric labels of the partition
+func (pc *PartitionConsumer) labels() []string {
+	return []string{pc.topic, metrics.PartitionLabel(pc.partition)}
+}
+
+// NewRecord converts a fetched kafka record into a models.Record, keeping its provenance and headers
+func NewRecord(record *kgo.Record) models.Record {
+	headers := make([]models.RecordHeader, len(record.Headers))
+	for idx, header := range record.Headers {
+		headers[idx] = models.RecordHeader{Key: header.Key, Value: header.Value}
+	}
+
+	return models.Record{
+		Key:       record.Key,
+		Value:     record.Value,
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+		Headers:   headers,
+	}
+}
+
+func (pc *PartitionConsumer) process(ctx context.Context, records []models.Record, offset models.PartitionOffset) []models.RecordResult {
+	if pc.exactlyOnce {
+		return pc.processor.ProcessRecordsWithOffset(ctx, records, offset)
+	}
+	return pc.processor.ProcessRecords(ctx, records)
+}
+
+// NewDeadLetter creates a dead letter from a failed record result, carrying the
+// failed fields along with the reason when the record was rejected as invalid.
+func NewDeadLetter(result models.RecordResult, attempts int) models.DeadLetter {
+	dl := models.DeadLetter{Record: result.Record, Attempts: attempts, FailedAt: time.Now().UTC()}
+	if result.Err == nil {
+		return dl
+	}
+
+	dl.Reason = result.Err.Error()
+	var appErr *errors.Error
+	if errors.As(result.Err, &appErr) {
+		dl.Reason = appErr.Message
+		if appErr.WrappedErr != nil {
+			dl.Reason = fmt.Sprintf("%s: %v", appErr.Message, appErr.WrappedErr)
+		}
+	}
+
+	var ve errors.ValidationErrors
+	if errors.As(result.Err, &ve) {
+		dl.Details = ve
+	}
+	return dl
+}
+
+// Poll polls records from the topic and hands them to the partition consumers until the context is
+// canceled, in which case it returns nil. Call Shutdown once Poll returns to drain and close the consumer.
+func (c *Consumer) Poll(ctx context.Context) error {
 	c.logger.Info(fmt.Sprintf("%s: Polling For Records", c.config.Name))
 	for {
 		// Check if the context is canceled before polling
 		if ctx.Err() != nil {
-			c.logger.Warn("polling stopped: context canceled")
-			return ctx.Err() // Exit gracefully
+			c.logger.Info("polling stopped: context canceled")
+			return nil
 		}
 
 		// Fetches a batch of records from Kafka based on the poll size
@@ -224,12 +756,13 @@ func (c *Consumer) Poll(ctx context.Context) error {
 
 		// Handle client shutdown
 		if fetches.IsClientClosed() {
-			return errors.New("kafka client closed")
+			return errors.NewError("kafka client closed")
 		}
 
 		// Handle context cancellation explicitly
 		if errors.Is(fetches.Err0(), context.Canceled) {
-			return errors.New("context got canceled")
+			c.logger.Info("polling stopped: context canceled")
+			return nil
 		}
 
 		fetches.EachError(func(topic string, partition int32, err error) {
@@ -237,8 +770,19 @@ func (c *Consumer) Poll(ctx context.Context) error {
 		})
 
 		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
-			tp := TopicPartition{p.Topic, p.Partition}
-			c.consumers[tp].recs <- p
+			c.mu.Lock()
+			pc, ok := c.consumers[TopicPartition{p.Topic, p.Partition}]
+			c.mu.Unlock()
+			if !ok {
+				return
+			}
+
+			// the partition is paused before its queue is full, so this only blocks if the watermarks allow it
+			select {
+			case pc.recs <- p:
+				pc.enqueued()
+			case <-ctx.Done():
+			}
 		})
 
 		c.client.AllowRebalance()
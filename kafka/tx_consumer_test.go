@@ -0,0 +1,451 @@
+package kafka
+
+import (
+	// Go Internal Packages
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	// Local Packages
+	breaker "tx-stream/breaker"
+	models "tx-stream/models"
+	mongodb "tx-stream/repositories/mongodb"
+	txpsr "tx-stream/services/processors"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestConsumerProcessesAndCommits(t *testing.T) {
+	h := newHarness(t, 2)
+	h.produce(txRecord(0, "tx-1"), txRecord(0, "tx-2"), txRecord(1, "tx-3"))
+
+	c := h.newConsumer(h.config("commits"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return h.repo.count() == 3 }, "3 stored transactions, got %d", h.repo.count())
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	committed := h.committed("commits")
+	if committed[0] != 2 || committed[1] != 1 {
+		t.Errorf("committed offsets = %v, want partition 0 at 2 and partition 1 at 1", committed)
+	}
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records, want none", len(sent))
+	}
+}
+
+func TestConsumerDeadLettersInvalidRecords(t *testing.T) {
+	h := newHarness(t, 1)
+	h.produce(
+		txRecord(0, "tx-1"),
+		&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("bad"), Value: []byte("{not json")},
+		txRecord(0, "tx-2"),
+	)
+
+	c := h.newConsumer(h.config("invalid"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return len(h.dlq.sent()) == 1 }, "1 dead letter")
+	eventually(t, func() bool { return h.repo.count() == 2 }, "2 stored transactions")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	dl := h.dlq.sent()[0]
+	if string(dl.Record.Key) != "bad" || dl.Record.Offset != 1 {
+		t.Errorf("dead letter is for key %q at offset %d, want bad at 1", dl.Record.Key, dl.Record.Offset)
+	}
+	if dl.Attempts != 1 {
+		t.Errorf("invalid record attempted %d times, want 1", dl.Attempts)
+	}
+	if dl.Reason == "" {
+		t.Error("dead letter has no reason")
+	}
+	if committed := h.committed("invalid"); committed[0] != 3 {
+		t.Errorf("committed offset = %d, want 3 past the dead-lettered record", committed[0])
+	}
+}
+
+func TestConsumerRetriesTransientFailures(t *testing.T) {
+	h := newHarness(t, 1)
+	h.repo.failNext(2)
+	h.produce(txRecord(0, "tx-1"))
+
+	c := h.newConsumer(h.config("retries"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return h.repo.count() == 1 }, "stored transaction")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if writes := h.repo.writeCount(); writes != 3 {
+		t.Errorf("store written %d times, want 3", writes)
+	}
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records, want none", len(sent))
+	}
+	if committed := h.committed("retries"); committed[0] != 1 {
+		t.Errorf("committed offset = %d, want 1", committed[0])
+	}
+}
+
+func TestConsumerDeadLettersExhaustedRetries(t *testing.T) {
+	h := newHarness(t, 1)
+	h.repo.failNext(3)
+	h.produce(txRecord(0, "tx-1"))
+
+	c := h.newConsumer(h.config("exhausted"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return len(h.dlq.sent()) == 1 }, "1 dead letter")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if dl := h.dlq.sent()[0]; dl.Attempts != 3 {
+		t.Errorf("dead letter attempted %d times, want 3", dl.Attempts)
+	}
+	if h.repo.count() != 0 {
+		t.Errorf("stored %d transactions, want none", h.repo.count())
+	}
+	if committed := h.committed("exhausted"); committed[0] != 1 {
+		t.Errorf("committed offset = %d, want 1 past the dead-lettered record", committed[0])
+	}
+}
+
+func TestConsumerWaitsOutStoreOutage(t *testing.T) {
+	h := newHarness(t, 1)
+	h.repo.setDown(true)
+	h.produce(txRecord(0, "tx-1"), txRecord(0, "tx-2"))
+
+	// the breaker opens on the last attempt of the batch
+	conf := h.config("outage")
+	storeBreaker := breaker.New("mongo", conf.Retry.MaxAttempts, 10*time.Millisecond, h.repo.ping, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go storeBreaker.Run(ctx)
+	h.breaker = storeBreaker
+
+	repo := mongodb.NewBreakerTxRepository(h.repo, storeBreaker)
+	c := h.newConsumer(conf, txpsr.NewTxProcessor(zap.NewNop(), repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, storeBreaker.Open, "circuit breaker open")
+	if stored := h.repo.count(); stored != 0 {
+		t.Fatalf("stored %d transactions while the breaker is open, want none", stored)
+	}
+	h.repo.setDown(false)
+	eventually(t, func() bool { return !storeBreaker.Open() }, "circuit breaker closed once the store is back")
+
+	eventually(t, func() bool { return h.repo.count() == 2 }, "2 stored transactions after the outage")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records during the outage, want none", len(sent))
+	}
+	if committed := h.committed("outage"); committed[0] != 2 {
+		t.Errorf("committed offset = %d, want 2", committed[0])
+	}
+}
+
+func TestConsumerRebalancesWhileBreakerOpen(t *testing.T) {
+	h := newHarness(t, 2)
+	h.repo.setDown(true)
+	h.produce(txRecord(0, "tx-1"), txRecord(1, "tx-2"))
+
+	conf := h.config("breaker-rebalance")
+	storeBreaker := breaker.New("mongo", conf.Retry.MaxAttempts, 10*time.Millisecond, h.repo.ping, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go storeBreaker.Run(ctx)
+	h.breaker = storeBreaker
+
+	repo := mongodb.NewBreakerTxRepository(h.repo, storeBreaker)
+	processor := txpsr.NewTxProcessor(zap.NewNop(), repo, nil, nil, nil, models.TransformOptions{})
+	first := h.newConsumer(conf, processor)
+	stopFirst := h.start(first, 5*time.Second)
+	eventually(t, storeBreaker.Open, "circuit breaker open")
+
+	// the revoked partition stops waiting for the breaker, so the rebalance completes
+	second := h.newConsumer(conf, processor)
+	stopSecond := h.start(second, 5*time.Second)
+	eventually(t, func() bool { return len(assigned(first)) == 1 && len(assigned(second)) == 1 },
+		"one partition each, got %v and %v", assigned(first), assigned(second))
+
+	h.repo.setDown(false)
+	eventually(t, func() bool { return h.repo.count() == 2 }, "2 stored transactions after the outage")
+	for _, stop := range []func() error{stopFirst, stopSecond} {
+		if err := stop(); err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	}
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records during the outage, want none", len(sent))
+	}
+}
+
+func TestConsumerRetriesFailedDeadLetters(t *testing.T) {
+	h := newHarness(t, 1)
+	h.dlq.failNext(2)
+	h.produce(&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("bad"), Value: []byte("{not json")})
+
+	c := h.newConsumer(h.config("dlq-retries"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return len(h.dlq.sent()) == 1 }, "1 dead letter")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if sends := h.dlq.sendCount(); sends != 3 {
+		t.Errorf("dlq sent to %d times, want 3", sends)
+	}
+	if committed := h.committed("dlq-retries"); committed[0] != 1 {
+		t.Errorf("committed offset = %d, want 1 past the dead-lettered record", committed[0])
+	}
+}
+
+func TestConsumerKeepsRecordsNotDeadLettered(t *testing.T) {
+	h := newHarness(t, 1)
+	h.dlq.failNext(1 << 20)
+	h.produce(&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("bad"), Value: []byte("{not json")})
+
+	c := h.newConsumer(h.config("dlq-down"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stop := h.start(c, 5*time.Second)
+
+	// shutting down ends the retries without waiting for the dlq
+	eventually(t, func() bool { return h.dlq.sendCount() >= 3 }, "retried dlq sends")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if _, ok := h.committed("dlq-down")[0]; ok {
+		t.Error("record which was not dead-lettered was committed")
+	}
+
+	// the record is redelivered to the next consumer of the group once the dlq is back
+	h.dlq.failNext(0)
+	next := h.newConsumer(h.config("dlq-down"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stopNext := h.start(next, 5*time.Second)
+	eventually(t, func() bool { return len(h.dlq.sent()) == 1 }, "redelivered record dead-lettered")
+	if err := stopNext(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if committed := h.committed("dlq-down"); committed[0] != 1 {
+		t.Errorf("committed offset = %d, want 1", committed[0])
+	}
+}
+
+func TestConsumerRebalancesWhileDeadLettersFail(t *testing.T) {
+	h := newHarness(t, 2)
+	h.dlq.failNext(1 << 20)
+	h.produce(
+		&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("bad-0"), Value: []byte("{not json")},
+		&kgo.Record{Topic: testTopic, Partition: 1, Key: []byte("bad-1"), Value: []byte("{not json")},
+	)
+	processor := txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{})
+
+	first := h.newConsumer(h.config("dlq-rebalance"), processor)
+	stopFirst := h.start(first, 5*time.Second)
+	eventually(t, func() bool { return h.dlq.sendCount() >= 2 }, "failing dlq sends")
+
+	// the revoked partition stops waiting for the dlq, so the rebalance completes
+	second := h.newConsumer(h.config("dlq-rebalance"), processor)
+	stopSecond := h.start(second, 5*time.Second)
+	eventually(t, func() bool { return len(assigned(first)) == 1 && len(assigned(second)) == 1 },
+		"one partition each, got %v and %v", assigned(first), assigned(second))
+
+	h.dlq.failNext(0)
+	eventually(t, func() bool { return len(h.dlq.sent()) >= 2 }, "both records dead-lettered")
+	for _, stop := range []func() error{stopFirst, stopSecond} {
+		if err := stop(); err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	}
+}
+
+func TestConsumerExactlyOnceStoresOffsetBeforeDeadLetters(t *testing.T) {
+	h := newHarness(t, 1)
+	h.dlq.failNext(1 << 20)
+	h.produce(
+		txRecord(0, "tx-1"),
+		&kgo.Record{Topic: testTopic, Partition: 0, Key: []byte("bad"), Value: []byte("{not json")},
+		txRecord(0, "tx-2"),
+	)
+
+	conf := h.config("exactly-once")
+	conf.ExactlyOnce = true
+	processor := txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{})
+	c := h.newConsumer(conf, processor)
+	stop := h.start(c, 5*time.Second)
+
+	// the consumer is stopped once the transactions are stored but before the invalid record is dead-lettered
+	eventually(t, func() bool { return h.repo.count() == 2 && h.dlq.sendCount() > 0 }, "stored transactions and a dlq send")
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if stored := h.storedOffsets("exactly-once"); stored[0] != 1 {
+		t.Fatalf("stored offset = %d, want 1 at the record which was not dead-lettered", stored[0])
+	}
+
+	// the record is redelivered from the stored offset once the dlq is back
+	h.dlq.failNext(0)
+	next := h.newConsumer(conf, processor)
+	stopNext := h.start(next, 5*time.Second)
+	eventually(t, func() bool { return len(h.dlq.sent()) == 1 }, "redelivered record dead-lettered")
+	if err := stopNext(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if stored := h.storedOffsets("exactly-once"); stored[0] != 3 {
+		t.Errorf("stored offset = %d, want 3 past the batch", stored[0])
+	}
+}
+
+func TestConsumerPausesFullPartitionUntilDrained(t *testing.T) {
+	h := newHarness(t, 1)
+	var records []*kgo.Record
+	for idx := range 20 {
+		records = append(records, txRecord(0, fmt.Sprintf("tx-%d", idx)))
+	}
+	h.produce(records...)
+
+	// one record per poll queues a batch per record, filling the queue past the high watermark
+	conf := h.config("backpressure")
+	conf.RecordsPerPoll = 1
+	conf.Batch.MaxRecords = 1
+	processor := &gatedProcessor{
+		TxProcessor: txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}),
+		gate:        make(chan struct{}),
+	}
+	c := h.newConsumer(conf, processor)
+	stop := h.start(c, 5*time.Second)
+
+	eventually(t, func() bool { return paused(c, 0) }, "partition paused at the high watermark")
+	close(processor.gate)
+
+	// draining the queue resumes fetching, so the records past the paused ones are consumed as well
+	eventually(t, func() bool { return h.repo.count() == 20 }, "20 stored transactions, got %d", h.repo.count())
+	if paused(c, 0) {
+		t.Error("partition still paused after its queue drained")
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if committed := h.committed("backpressure"); committed[0] != 20 {
+		t.Errorf("committed offset = %d, want 20", committed[0])
+	}
+}
+
+func TestConsumerRebalance(t *testing.T) {
+	h := newHarness(t, 2)
+	processor := txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{})
+
+	first := h.newConsumer(h.config("rebalance"), processor)
+	stopFirst := h.start(first, 5*time.Second)
+	eventually(t, func() bool { return len(assigned(first)) == 2 }, "both partitions assigned to the first consumer")
+
+	second := h.newConsumer(h.config("rebalance"), processor)
+	stopSecond := h.start(second, 5*time.Second)
+	eventually(t, func() bool { return len(assigned(first)) == 1 && len(assigned(second)) == 1 },
+		"one partition each, got %v and %v", assigned(first), assigned(second))
+
+	if got := append(assigned(first), assigned(second)...); !slices.Contains(got, 0) || !slices.Contains(got, 1) {
+		t.Fatalf("assigned partitions = %v, want 0 and 1", got)
+	}
+
+	h.produce(txRecord(0, "tx-1"), txRecord(1, "tx-2"))
+	eventually(t, func() bool { return h.repo.count() == 2 }, "2 stored transactions")
+
+	// the revoked partitions move back to the remaining consumer
+	if err := stopSecond(); err != nil {
+		t.Fatalf("shutdown of second consumer failed: %v", err)
+	}
+	eventually(t, func() bool { return len(assigned(first)) == 2 }, "both partitions back on the first consumer")
+
+	h.produce(txRecord(0, "tx-3"), txRecord(1, "tx-4"))
+	eventually(t, func() bool { return h.repo.count() == 4 }, "4 stored transactions")
+	if err := stopFirst(); err != nil {
+		t.Fatalf("shutdown of first consumer failed: %v", err)
+	}
+
+	if committed := h.committed("rebalance"); committed[0] != 2 || committed[1] != 2 {
+		t.Errorf("committed offsets = %v, want both partitions at 2", committed)
+	}
+}
+
+func TestConsumerShutdownAbortsInFlightBatch(t *testing.T) {
+	h := newHarness(t, 1)
+	h.produce(txRecord(0, "tx-1"))
+
+	processor := newBlockingProcessor()
+	c := h.newConsumer(h.config("shutdown"), processor)
+	stop := h.start(c, 100*time.Millisecond)
+
+	select {
+	case <-processor.started:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the batch to be processed")
+	}
+	if err := stop(); err == nil {
+		t.Fatal("shutdown succeeded, want the drain timeout to be reported")
+	}
+	if _, ok := h.committed("shutdown")[0]; ok {
+		t.Error("aborted batch was committed")
+	}
+	if sent := h.dlq.sent(); len(sent) != 0 {
+		t.Errorf("dead-lettered %d records, want none", len(sent))
+	}
+
+	// the aborted record is redelivered to the next consumer of the group
+	next := h.newConsumer(h.config("shutdown"), txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}))
+	stopNext := h.start(next, 5*time.Second)
+	eventually(t, func() bool { return h.repo.count() == 1 }, "redelivered transaction stored")
+	if err := stopNext(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if committed := h.committed("shutdown"); committed[0] != 1 {
+		t.Errorf("committed offset = %d, want 1", committed[0])
+	}
+}
+
+func TestConsumerShutdownCommitsAfterDrainTimeout(t *testing.T) {
+	h := newHarness(t, 2)
+	h.produce(txRecord(0, "tx-1"), txRecord(1, "tx-2"))
+	core, logs := observer.New(zap.ErrorLevel)
+	h.logger = zap.New(core)
+
+	processor := &stuckPartitionProcessor{
+		TxProcessor: txpsr.NewTxProcessor(zap.NewNop(), h.repo, nil, nil, nil, models.TransformOptions{}),
+		partition:   1,
+		stuck:       newBlockingProcessor(),
+	}
+	c := h.newConsumer(h.config("shutdown-commit"), processor)
+	stop := h.start(c, 100*time.Millisecond)
+
+	eventually(t, func() bool { return h.repo.count() == 1 }, "transaction of the free partition stored")
+	<-processor.stuck.started
+	if err := stop(); err == nil {
+		t.Fatal("shutdown succeeded, want the drain timeout to be reported")
+	}
+
+	// the offset marked before the drain timed out is still committed
+	if failed := logs.FilterMessage("failed to commit marked offsets").Len(); failed != 0 {
+		t.Errorf("committing the marked offsets failed %d times after the drain timeout", failed)
+	}
+	committed := h.committed("shutdown-commit")
+	if committed[0] != 1 {
+		t.Errorf("committed offset of partition 0 = %d, want 1", committed[0])
+	}
+	if offset, ok := committed[1]; ok {
+		t.Errorf("committed offset of the aborted partition = %d, want none", offset)
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	// Go Internal Packages
+	"strconv"
+	"time"
+
+	// External Packages
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the application metrics, exported alongside the kafka client metrics
+type Metrics struct {
+	RecordsProcessed    *prometheus.CounterVec
+	RecordsFailed       *prometheus.CounterVec
+	RecordsDeadLettered *prometheus.CounterVec
+	Retries             *prometheus.CounterVec
+	BatchSize           *prometheus.HistogramVec
+	ProcessingLatency   *prometheus.HistogramVec
+	StoreLatency        *prometheus.HistogramVec
+	ConsumerLag         *prometheus.GaugeVec
+	QueueDepth          *prometheus.GaugeVec
+	PartitionPaused     *prometheus.GaugeVec
+	BreakerOpen         *prometheus.GaugeVec
+}
+
+// New creates the application metrics and registers them with the registerer
+func New(namespace string, reg prometheus.Registerer) *Metrics {
+	partitionLabels := []string{"topic", "partition"}
+	m := &Metrics{
+		RecordsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "records_processed_total",
+			Help:      "Records processed successfully",
+		}, partitionLabels),
+		RecordsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "records_failed_total",
+			Help:      "Records which failed permanently or exhausted their retries",
+		}, partitionLabels),
+		RecordsDeadLettered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "records_dead_lettered_total",
+			Help:      "Records sent to the dead-letter queue",
+		}, partitionLabels),
+		Retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "record_retries_total",
+			Help:      "Records retried after a retryable failure",
+		}, partitionLabels),
+		BatchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_size_records",
+			Help:      "Records in each processed batch",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"topic"}),
+		ProcessingLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_processing_seconds",
+			Help:      "Time taken to process a batch including retries",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"topic"}),
+		StoreLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_seconds",
+			Help:      "Latency of mongo and redis operations",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"store", "operation", "status"}),
+		ConsumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "consumer_lag_records",
+			Help:      "Records between the committed offset and the high watermark",
+		}, partitionLabels),
+		QueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "partition_queue_depth",
+			Help:      "Fetched batches waiting to be processed by the partition consumer",
+		}, partitionLabels),
+		PartitionPaused: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "partition_paused",
+			Help:      "Whether fetching the partition is paused due to backpressure",
+		}, partitionLabels),
+		BreakerOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_open",
+			Help:      "Whether the circuit breaker of a store is open",
+		}, []string{"store"}),
+	}
+
+	reg.MustRegister(
+		m.RecordsProcessed,
+		m.RecordsFailed,
+		m.RecordsDeadLettered,
+		m.Retries,
+		m.BatchSize,
+		m.ProcessingLatency,
+		m.StoreLatency,
+		m.ConsumerLag,
+		m.QueueDepth,
+		m.PartitionPaused,
+		m.BreakerOpen,
+	)
+	return m
+}
+
+// ObserveStore records the latency of a store operation
+func (m *Metrics) ObserveStore(store, operation string, took time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.StoreLatency.WithLabelValues(store, operation, status).Observe(took.Seconds())
+}
+
+// PartitionLabel formats a partition as a label value
+func PartitionLabel(partition int32) string {
+	return strconv.FormatInt(int64(partition), 10)
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	// Go Internal Packages
+	"context"
+	"errors"
+	"time"
+
+	// External Packages
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// MongoMonitor returns a command monitor recording the latency of every mongo command
+func (m *Metrics) MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.ObserveStore("mongo", e.CommandName, e.Duration, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.ObserveStore("mongo", e.CommandName, e.Duration, errors.New(e.Failure))
+		},
+	}
+}
+
+// RedisHook returns a hook recording the latency of every redis command and pipeline
+func (m *Metrics) RedisHook() redis.Hook {
+	return redisHook{metrics: m}
+}
+
+type redisHook struct {
+	metrics *Metrics
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.metrics.ObserveStore("redis", cmd.Name(), time.Since(start), ignoreNil(err))
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.metrics.ObserveStore("redis", "pipeline", time.Since(start), ignoreNil(err))
+		return err
+	}
+}
+
+// ignoreNil treats redis.Nil, returned when a key does not exist, as a successful operation
+func ignoreNil(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"fmt"
+	"math/big"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AmountFormat decides how amounts are stored in mongo
+type AmountFormat string
+
+const (
+	AmountDecimal    AmountFormat = "decimal"     // Decimal128 holding the exact amount
+	AmountMinorUnits AmountFormat = "minor_units" // Int64 count of the currency's minor units, e.g. paise
+)
+
+// IsValid reports whether the amount format is known
+func (f AmountFormat) IsValid() bool {
+	return f == AmountDecimal || f == AmountMinorUnits
+}
+
+// Amount is an exact decimal amount, kept as the decimal literal it was parsed from. The zero value is zero.
+type Amount struct {
+	literal string
+	value   *big.Rat
+}
+
+// ParseAmount parses a decimal literal like "1499.99"
+func ParseAmount(literal string) (Amount, error) {
+	value, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return Amount{}, fmt.Errorf("invalid decimal amount %q", literal)
+	}
+	return Amount{literal: literal, value: value}, nil
+}
+
+// UnmarshalJSON parses the amount from a JSON number without going through a float
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) == 0 || data[0] == '"' {
+		return fmt.Errorf("amount must be a JSON number, got %s", data)
+	}
+
+	amount, err := ParseAmount(string(data))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// MarshalJSON writes the amount as the JSON number it was parsed from
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// String returns the decimal literal of the amount
+func (a Amount) String() string {
+	if a.value == nil {
+		return "0"
+	}
+	return a.literal
+}
+
+// Rat returns the exact value of the amount
+func (a Amount) Rat() *big.Rat {
+	if a.value == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(a.value)
+}
+
+// Cmp compares the amounts, returning -1, 0 or +1 like big.Rat.Cmp
+func (a Amount) Cmp(b Amount) int {
+	return a.Rat().Cmp(b.Rat())
+}
+
+// Decimal128 returns the amount as a BSON Decimal128
+func (a Amount) Decimal128() (primitive.Decimal128, error) {
+	return primitive.ParseDecimal128(a.String())
+}
+
+// MinorUnits returns the amount in minor units of the currency, e.g. paise for INR. Fails if the
+// amount has more decimal places than the currency has minor units or does not fit an int64.
+func (a Amount) MinorUnits(currency string) (int64, error) {
+	exponent := MinorUnitExponent(currency)
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+	scaled := new(big.Rat).Mul(a.Rat(), new(big.Rat).SetInt(unit))
+	if !scaled.IsInt() {
+		return 0, fmt.Errorf("has more than %d decimal places for %s", exponent, currency)
+	}
+	if !scaled.Num().IsInt64() {
+		return 0, fmt.Errorf("is too large to store in minor units")
+	}
+	return scaled.Num().Int64(), nil
+}
+
+// MongoAmount is an amount as stored in mongo, a Decimal128 or an Int64 count of minor units depending on Format
+type MongoAmount struct {
+	Format     AmountFormat
+	Decimal    primitive.Decimal128
+	MinorUnits int64
+}
+
+// NewMongoAmount converts the amount into the given storage format
+func NewMongoAmount(amount Amount, currency string, format AmountFormat) (MongoAmount, error) {
+	if format == AmountMinorUnits {
+		units, err := amount.MinorUnits(currency)
+		return MongoAmount{Format: format, MinorUnits: units}, err
+	}
+
+	decimal, err := amount.Decimal128()
+	return MongoAmount{Format: AmountDecimal, Decimal: decimal}, err
+}
+
+// MarshalBSONValue stores the amount as a Decimal128 or an Int64 depending on its format
+func (a MongoAmount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if a.Format == AmountMinorUnits {
+		return bson.MarshalValue(a.MinorUnits)
+	}
+	return bson.MarshalValue(a.Decimal)
+}
+
+// UnmarshalBSONValue reads back an amount stored in either format
+func (a *MongoAmount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bson.TypeDecimal128:
+		a.Format, a.Decimal = AmountDecimal, raw.Decimal128()
+	case bson.TypeInt64:
+		a.Format, a.MinorUnits = AmountMinorUnits, raw.Int64()
+	default:
+		return fmt.Errorf("cannot decode amount from bson %s", t)
+	}
+	return nil
+}
+
+// minorUnitExponents lists the ISO 4217 currencies whose minor unit is not a hundredth
+var minorUnitExponents = map[string]int32{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnitExponent returns the number of decimal places of the currency's minor unit, 2 unless listed otherwise
+func MinorUnitExponent(currency string) int32 {
+	if exponent, ok := minorUnitExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
@@ -1,9 +1,109 @@
 package models
 
+import (
+	// Go Internal Packages
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+)
+
+// Well known record headers available to the processing logic
+const (
+	HeaderTraceID       = "trace_id"
+	HeaderSchemaVersion = "schema_version"
+	HeaderContentType   = "content-type"
+	HeaderPIISanitized  = "pii_sanitized"
+)
+
+// ValueFormat is the encoding of a record value, selected by its content-type header
+type ValueFormat string
+
+const (
+	FormatJSON     ValueFormat = "json"
+	FormatAvro     ValueFormat = "avro"     // Confluent wire format with the writer schema in the registry
+	FormatProtobuf ValueFormat = "protobuf" // Confluent wire format with the writer schema in the registry
+)
+
+// IsValid reports whether the value format is known
+func (f ValueFormat) IsValid() bool {
+	return f == FormatJSON || f == FormatAvro || f == FormatProtobuf
+}
+
 type Record struct {
-	Key   []byte
+	Key       []byte
+	Value     []byte
+	Topic     string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	Headers   []RecordHeader
+}
+
+type RecordHeader struct {
+	Key   string
 	Value []byte
-	Topic string
+}
+
+// Header returns the value of the last header with the given key
+func (r *Record) Header(key string) (string, bool) {
+	for idx := len(r.Headers) - 1; idx >= 0; idx-- {
+		if r.Headers[idx].Key == key {
+			return string(r.Headers[idx].Value), true
+		}
+	}
+	return "", false
+}
+
+// WithHeader returns a copy of the record with the header set, replacing the existing headers with the same key
+func (r Record) WithHeader(key, value string) Record {
+	headers := make([]RecordHeader, 0, len(r.Headers)+1)
+	for _, header := range r.Headers {
+		if header.Key != key {
+			headers = append(headers, header)
+		}
+	}
+	r.Headers = append(headers, RecordHeader{Key: key, Value: []byte(value)})
+	return r
+}
+
+// Outcome classifies the result of processing a single record.
+type Outcome uint8
+
+const (
+	Succeeded        Outcome = iota // Record was processed
+	RetryableFailure                // Record failed but may succeed when retried
+	PermanentFailure                // Record can never succeed and must be dead-lettered
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Succeeded:
+		return "succeeded"
+	case RetryableFailure:
+		return "retryable failure"
+	case PermanentFailure:
+		return "permanent failure"
+	default:
+		return "unknown outcome"
+	}
+}
+
+// RecordResult is the outcome of processing a single record along with the reason it failed.
+type RecordResult struct {
+	Record  Record
+	Outcome Outcome
+	Err     error
+}
+
+// DeadLetter is a record that could not be processed along with why and how many times it was attempted.
+// Details lists the fields that failed validation, when the record was rejected as invalid.
+type DeadLetter struct {
+	Record   Record                  `json:"record"`
+	Reason   string                  `json:"reason"`
+	Details  errors.ValidationErrors `json:"details,omitempty"`
+	Attempts int                     `json:"attempts"`
+	FailedAt time.Time               `json:"failed_at"`
 }
 
 type ConsumerConfig struct {
@@ -11,5 +111,83 @@ type ConsumerConfig struct {
 	Name                  string
 	Topic                 string
 	EachPartitionChanSize int
+	QueueHighWatermark    int // Queued batches at which fetching the partition is paused
+	QueueLowWatermark     int // Queued batches at which fetching the partition is resumed
 	RecordsPerPoll        int
+	ExactlyOnce           bool
+	Batch                 BatchPolicy
+	Parallel              ParallelPolicy
+	Retry                 RetryPolicy
+}
+
+// ParallelPolicy decides how many workers process the batches of a partition, records with the
+// same ordering key (the record key or the user id) are always processed in order.
+type ParallelPolicy struct {
+	Concurrency int
+	KeyBy       KeyBy
+}
+
+// KeyBy is the ordering key of the records processed in parallel
+type KeyBy string
+
+const (
+	KeyByRecordKey KeyBy = "record_key"
+	KeyByUserID    KeyBy = "user_id" // Read from JSON values
+)
+
+// IsValid reports whether the ordering key is known
+func (k KeyBy) IsValid() bool {
+	return k == KeyByRecordKey || k == KeyByUserID
+}
+
+// BatchPolicy decides when the records accumulated for a partition are processed, whichever limit is reached first.
+type BatchPolicy struct {
+	MaxRecords int
+	MaxBytes   int           // Sum of the key and value sizes
+	Linger     time.Duration // How long the first record may wait for the batch to fill up
+}
+
+// LagConfig configures how often the consumer lag is computed and when it is worth a warning.
+type LagConfig struct {
+	Interval          time.Duration
+	Threshold         int64         // Lag in records above which a partition is considered behind
+	ThresholdDuration time.Duration // How long a partition must stay behind before warning
+}
+
+// RetryPolicy decides how many times and how often failed records are retried before being dead-lettered.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Jitter      Jitter
+	Deadline    time.Duration // Total time allowed for all attempts of a batch, unbounded when zero
+}
+
+// Jitter is the strategy randomizing the exponential retry backoff
+type Jitter string
+
+const (
+	NoJitter    Jitter = "none"  // Wait exactly the exponential backoff
+	FullJitter  Jitter = "full"  // Wait a random duration between zero and the backoff
+	EqualJitter Jitter = "equal" // Wait half the backoff plus a random duration up to the other half
+)
+
+// IsValid reports whether the jitter is one of the known jitter strategies
+func (j Jitter) IsValid() bool {
+	return j == NoJitter || j == FullJitter || j == EqualJitter
+}
+
+// PartitionOffset is the next offset to consume for a topic partition of a consumer group.
+// In exactly-once mode it is stored in MongoDB along with the batch it was derived from.
+type PartitionOffset struct {
+	Group     string `bson:"group"`
+	Topic     string `bson:"topic"`
+	Partition int32  `bson:"partition"`
+	Offset    int64  `bson:"offset"`
+}
+
+// DeadLetterEntry is a dead letter read back from the dead-letter queue, ID identifies it within the queue.
+type DeadLetterEntry struct {
+	ID         string
+	DeadLetter DeadLetter
 }
@@ -0,0 +1,67 @@
+package models
+
+import (
+	// Go Internal Packages
+	"fmt"
+	"time"
+)
+
+// StatusChange is an entry of the status history of a stored transaction
+type StatusChange struct {
+	Status     string    `json:"status" bson:"status"`
+	Timestamp  time.Time `json:"timestamp" bson:"timestamp"`
+	Source     Source    `json:"source" bson:"source"`
+	IngestedAt time.Time `json:"ingested_at" bson:"ingested_at"`
+}
+
+// ChangeOf returns the status change recorded by the stored transaction
+func ChangeOf(tx MongoTransaction) StatusChange {
+	return StatusChange{Status: tx.Status, Timestamp: tx.Timestamp, Source: tx.Source, IngestedAt: tx.IngestedAt}
+}
+
+// Lifecycle is the state machine of transaction statuses. A transaction is first stored with one of the
+// initial statuses and then moves along the transitions, e.g. pending to success or failed, and success
+// to refunded.
+type Lifecycle struct {
+	initial     map[string]bool
+	transitions map[string]map[string]bool
+}
+
+// NewLifecycle creates the state machine starting at the initial statuses and moving from each status
+// to the statuses it maps to
+func NewLifecycle(initial []string, transitions map[string][]string) (*Lifecycle, error) {
+	if len(initial) == 0 {
+		return nil, fmt.Errorf("lifecycle has no initial status")
+	}
+
+	l := &Lifecycle{initial: make(map[string]bool, len(initial)), transitions: make(map[string]map[string]bool)}
+	for _, status := range initial {
+		if status == "" {
+			return nil, fmt.Errorf("lifecycle has an empty initial status")
+		}
+		l.initial[status] = true
+	}
+	for from, to := range transitions {
+		if from == "" {
+			return nil, fmt.Errorf("lifecycle has a transition from an empty status")
+		}
+		l.transitions[from] = make(map[string]bool, len(to))
+		for _, status := range to {
+			if status == "" {
+				return nil, fmt.Errorf("lifecycle has a transition from %s to an empty status", from)
+			}
+			l.transitions[from][status] = true
+		}
+	}
+	return l, nil
+}
+
+// CanStart reports whether a transaction can first be stored with the status
+func (l *Lifecycle) CanStart(status string) bool {
+	return l.initial[status]
+}
+
+// CanMove reports whether a stored transaction can move from one status to the other
+func (l *Lifecycle) CanMove(from, to string) bool {
+	return l.transitions[from][to]
+}
@@ -0,0 +1,379 @@
+package models
+
+import (
+	// Go Internal Packages
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldType is the type a projected field is coerced into
+type FieldType string
+
+const (
+	FieldAsIs      FieldType = ""          // Stored as it was received
+	FieldString    FieldType = "string"    // Stored as a string, numbers and booleans are formatted
+	FieldInt       FieldType = "int"       // Stored as a 64-bit integer, parsed from strings
+	FieldFloat     FieldType = "float"     // Stored as a double, parsed from strings
+	FieldBool      FieldType = "bool"      // Stored as a boolean, parsed from strings
+	FieldDecimal   FieldType = "decimal"   // Stored as an exact Decimal128
+	FieldAmount    FieldType = "amount"    // Stored like the amount, in the configured amount format
+	FieldTimestamp FieldType = "timestamp" // Stored as a date, parsed with the configured timestamp layouts
+)
+
+// IsValid reports whether the field type is known
+func (t FieldType) IsValid() bool {
+	switch t {
+	case FieldAsIs, FieldString, FieldInt, FieldFloat, FieldBool, FieldDecimal, FieldAmount, FieldTimestamp:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExtraField is the sub-document holding the fields a transaction is not known to have
+const ExtraField = "extra"
+
+// reservedFields are stored from the typed fields of MongoTransaction and cannot be projected into
+var reservedFields = map[string]bool{
+	"_id":            true,
+	"amount":         true,
+	"timestamp":      true,
+	"status":         true,
+	"status_history": true,
+	"source":         true,
+	"ingested_at":    true,
+}
+
+// coreFields are the incoming fields always stored through the typed fields of MongoTransaction
+var coreFields = map[string]bool{"transaction_id": true, "amount": true, "timestamp": true, "status": true}
+
+// knownFields maps the JSON fields of a Transaction to the type they are stored as by default
+var knownFields = transactionFields()
+
+// FieldMapping stores the field at the dotted path From under the dotted path To, coerced into Type
+type FieldMapping struct {
+	From string
+	To   string
+	Type FieldType
+}
+
+// Projection declares which fields of an incoming transaction are stored and how. With no include list every
+// field of a Transaction is stored under its own name, while fields it does not know are dropped, or kept
+// under ExtraField when keepExtra is set.
+type Projection struct {
+	include   []string
+	exclude   map[string]bool
+	mappings  map[string]FieldMapping
+	keepExtra bool
+}
+
+// DefaultProjection stores every field of a Transaction under its own name
+var DefaultProjection = &Projection{exclude: map[string]bool{}, mappings: map[string]FieldMapping{}}
+
+// NewProjection creates a projection of the include paths, or of every known field and mapped path when
+// include is empty, leaving out the exclude paths and anything nested under them.
+func NewProjection(include, exclude []string, mappings []FieldMapping, keepExtra bool) (*Projection, error) {
+	p := &Projection{
+		include:   include,
+		exclude:   make(map[string]bool, len(exclude)),
+		mappings:  make(map[string]FieldMapping, len(mappings)),
+		keepExtra: keepExtra,
+	}
+	for _, path := range exclude {
+		p.exclude[path] = true
+	}
+
+	targets := make(map[string]bool)
+	for _, m := range mappings {
+		if m.From == "" {
+			return nil, fmt.Errorf("field mapping to %q has no source path", m.To)
+		}
+		if coreFields[m.From] {
+			return nil, fmt.Errorf("field %s is always stored and cannot be mapped", m.From)
+		}
+		if m.To == "" {
+			m.To = m.From
+		}
+		if !m.Type.IsValid() {
+			return nil, fmt.Errorf("unknown type %q for field %s", m.Type, m.From)
+		}
+		if err := checkTarget(m.To, keepExtra); err != nil {
+			return nil, err
+		}
+		if targets[m.To] {
+			return nil, fmt.Errorf("fields %s are all stored as %s", m.From, m.To)
+		}
+		targets[m.To] = true
+		p.mappings[m.From] = m
+	}
+	for _, path := range include {
+		if _, mapped := p.mappings[path]; !mapped && !coreFields[path] {
+			if err := checkTarget(path, keepExtra); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// a field stored under another name must not overwrite, or be overwritten by, any other stored field
+	paths := p.projected()
+	for _, from := range paths {
+		m, ok := p.mappings[from]
+		if !ok || m.To == from {
+			continue
+		}
+		for _, other := range paths {
+			if target := p.target(other); other != from && overlaps(m.To, target) {
+				return nil, fmt.Errorf("field %s is stored as %s, which clashes with the stored %s", from, m.To, target)
+			}
+		}
+	}
+	return p, nil
+}
+
+// overlaps reports whether either dotted path is the other or nested under it
+func overlaps(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// checkTarget rejects storing a field where a typed field of MongoTransaction or the extra fields are stored
+func checkTarget(path string, keepExtra bool) error {
+	root, _, _ := strings.Cut(path, ".")
+	if reservedFields[root] {
+		return fmt.Errorf("field %s clashes with the stored %s", path, root)
+	}
+	if keepExtra && root == ExtraField {
+		return fmt.Errorf("field %s clashes with the extra fields", path)
+	}
+	return nil
+}
+
+// Project returns the fields of the document to store, coerced with the conversion options. The fields
+// always stored through the typed fields of MongoTransaction are left out. Returns a map of path to
+// message for the fields which cannot be coerced.
+func (p *Projection) Project(doc map[string]any, currency string, opts TransformOptions) (map[string]any, map[string]string) {
+	stored := make(map[string]any)
+	failed := make(map[string]string)
+
+	for _, path := range p.projected() {
+		value, ok := lookup(doc, path)
+		if !ok || value == nil {
+			continue
+		}
+
+		m, ok := p.mappings[path]
+		if !ok {
+			m = FieldMapping{From: path, To: path, Type: knownFields[path]}
+		}
+		coerced, err := coerce(value, m.Type, currency, opts)
+		if err != nil {
+			failed[path] = err.Error()
+			continue
+		}
+		set(stored, m.To, coerced)
+	}
+
+	if p.keepExtra {
+		extra := make(map[string]any)
+		for field, value := range doc {
+			if _, known := knownFields[field]; !known && !p.projects(field) && !p.excluded(field) {
+				extra[field] = value
+			}
+		}
+		if len(extra) > 0 {
+			stored[ExtraField] = extra
+		}
+	}
+	return stored, failed
+}
+
+// projected returns the paths of the incoming fields the projection stores, leaving out the core fields
+func (p *Projection) projected() []string {
+	paths := p.include
+	if len(paths) == 0 {
+		paths = p.defaultPaths()
+	}
+
+	projected := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !coreFields[path] && !p.excluded(path) {
+			projected = append(projected, path)
+		}
+	}
+	return projected
+}
+
+// target returns the path the field at path is stored under
+func (p *Projection) target(path string) string {
+	if m, ok := p.mappings[path]; ok {
+		return m.To
+	}
+	return path
+}
+
+// defaultPaths returns every known field along with the mapped paths, sorted
+func (p *Projection) defaultPaths() []string {
+	paths := make([]string, 0, len(knownFields)+len(p.mappings))
+	for field := range knownFields {
+		paths = append(paths, field)
+	}
+	for from := range p.mappings {
+		if _, known := knownFields[from]; !known {
+			paths = append(paths, from)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// excluded reports whether the path or any of its parents is excluded
+func (p *Projection) excluded(path string) bool {
+	for {
+		if p.exclude[path] {
+			return true
+		}
+		idx := strings.LastIndexByte(path, '.')
+		if idx < 0 {
+			return false
+		}
+		path = path[:idx]
+	}
+}
+
+// projects reports whether a field at the top level is already stored through the include list or a mapping
+func (p *Projection) projects(field string) bool {
+	within := func(path string) bool { return path == field || strings.HasPrefix(path, field+".") }
+	for _, path := range p.include {
+		if within(path) {
+			return true
+		}
+	}
+	for from := range p.mappings {
+		if within(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// coerce converts a decoded JSON value into the field type
+func coerce(value any, typ FieldType, currency string, opts TransformOptions) (any, error) {
+	switch typ {
+	case FieldString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case FieldInt:
+		if n, ok := number(value); ok {
+			if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case FieldFloat:
+		if n, ok := number(value); ok {
+			if f, err := strconv.ParseFloat(n, 64); err == nil {
+				return f, nil
+			}
+		}
+	case FieldBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+	case FieldDecimal, FieldAmount:
+		n, ok := number(value)
+		if !ok {
+			break
+		}
+		amount, err := ParseAmount(n)
+		if err != nil {
+			break
+		}
+		if typ == FieldDecimal {
+			return amount.Decimal128()
+		}
+		return NewMongoAmount(amount, currency, opts.AmountFormat)
+	case FieldTimestamp:
+		if v, ok := value.(string); ok {
+			if ts, ok := ParseTimestamp(v, opts.TimestampLayouts); ok {
+				return ts, nil
+			}
+			return nil, fmt.Errorf("does not match any accepted layout")
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot be stored as %s", typ)
+}
+
+// number returns the literal of a JSON number or of a string holding one
+func number(value any) (string, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), true
+	case string:
+		return strings.TrimSpace(v), true
+	default:
+		return "", false
+	}
+}
+
+// lookup returns the value at the dotted path of the document
+func lookup(doc map[string]any, path string) (any, bool) {
+	var value any = doc
+	for _, key := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// set stores the value at the dotted path of the document, creating the sub-documents on the way
+func set(doc map[string]any, path string, value any) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := doc[key].(map[string]any)
+		if !ok {
+			nested = make(map[string]any)
+			doc[key] = nested
+		}
+		doc = nested
+	}
+	doc[keys[len(keys)-1]] = value
+}
+
+// transactionFields returns the JSON fields of a Transaction with the type their Go type is stored as
+func transactionFields() map[string]FieldType {
+	amountType := reflect.TypeOf(Amount{})
+	txType := reflect.TypeOf(Transaction{})
+
+	fields := make(map[string]FieldType, txType.NumField())
+	for idx := 0; idx < txType.NumField(); idx++ {
+		field := txType.Field(idx)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch field.Type {
+		case amountType:
+			fields[name] = FieldAmount
+		default:
+			fields[name] = FieldString
+		}
+	}
+	return fields
+}
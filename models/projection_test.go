@@ -0,0 +1,159 @@
+package models
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const projectionDoc = `{"transaction_id":"tx-1","amount":10.50,"currency":"INR","timestamp":"2025-01-01T00:00:00Z",
+"user_id":"u-1","merchant_name":"Acme","discount":"1.25","card_number":"**** 1111",
+"merchant":{"id":"m-1","score":"4"},"settled_at":"2025-01-02T00:00:00Z","channel":"app"}`
+
+func decodeDoc(t *testing.T) map[string]any {
+	t.Helper()
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader([]byte(projectionDoc)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatalf("cannot decode document: %v", err)
+	}
+	return doc
+}
+
+func TestProjectionProject(t *testing.T) {
+	discount, _ := ParseAmount("1.25")
+	mongoDiscount, _ := NewMongoAmount(discount, "INR", AmountDecimal)
+	settledAt := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	opts := TransformOptions{AmountFormat: AmountDecimal}
+
+	tests := []struct {
+		name      string
+		include   []string
+		exclude   []string
+		mappings  []FieldMapping
+		keepExtra bool
+		want      map[string]any
+	}{
+		{
+			name: "every known field",
+			want: map[string]any{"currency": "INR", "user_id": "u-1", "merchant_name": "Acme",
+				"discount": mongoDiscount, "card_number": "**** 1111"},
+		},
+		{
+			name:    "excluded fields",
+			exclude: []string{"card_number", "discount"},
+			want:    map[string]any{"currency": "INR", "user_id": "u-1", "merchant_name": "Acme"},
+		},
+		{
+			name:    "included paths renamed and coerced",
+			include: []string{"transaction_id", "user_id", "merchant.id", "merchant.score", "settled_at"},
+			mappings: []FieldMapping{
+				{From: "merchant.id", To: "merchant_id"},
+				{From: "merchant.score", To: "ratings.merchant", Type: FieldInt},
+				{From: "settled_at", Type: FieldTimestamp},
+			},
+			want: map[string]any{"user_id": "u-1", "merchant_id": "m-1",
+				"ratings": map[string]any{"merchant": int64(4)}, "settled_at": settledAt},
+		},
+		{
+			name:      "extra fields",
+			include:   []string{"user_id", "merchant.id"},
+			exclude:   []string{"channel"},
+			keepExtra: true,
+			want: map[string]any{"user_id": "u-1", "merchant": map[string]any{"id": "m-1"},
+				ExtraField: map[string]any{"settled_at": "2025-01-02T00:00:00Z"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProjection(tt.include, tt.exclude, tt.mappings, tt.keepExtra)
+			if err != nil {
+				t.Fatalf("NewProjection() error = %v", err)
+			}
+			got, failed := p.Project(decodeDoc(t), "INR", opts)
+			if len(failed) > 0 {
+				t.Fatalf("Project() failed fields %v", failed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Project() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectionCoercionFailures(t *testing.T) {
+	p, err := NewProjection(nil, nil, []FieldMapping{{From: "merchant_name", Type: FieldInt}}, false)
+	if err != nil {
+		t.Fatalf("NewProjection() error = %v", err)
+	}
+	if _, failed := p.Project(decodeDoc(t), "INR", TransformOptions{}); failed["merchant_name"] == "" {
+		t.Errorf("Project() failed fields %v, want merchant_name", failed)
+	}
+}
+
+func TestNewProjectionTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		mappings []FieldMapping
+	}{
+		{name: "excluded default", exclude: []string{"category"}, mappings: []FieldMapping{{From: "merchant.category", To: "category"}}},
+		{name: "renamed default", mappings: []FieldMapping{{From: "category", To: "kind"}, {From: "merchant.category", To: "category"}}},
+		{name: "not included", include: []string{"merchant.category"}, mappings: []FieldMapping{{From: "merchant.category", To: "category"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProjection(tt.include, tt.exclude, tt.mappings, false); err != nil {
+				t.Errorf("NewProjection() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNewProjectionErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		include   []string
+		mappings  []FieldMapping
+		keepExtra bool
+	}{
+		{name: "reserved target", mappings: []FieldMapping{{From: "settled_at", To: "timestamp"}}},
+		{name: "reserved include", include: []string{"source.topic"}},
+		{name: "mapped core field", mappings: []FieldMapping{{From: "amount", To: "value"}}},
+		{name: "unknown type", mappings: []FieldMapping{{From: "user_id", Type: "uuid"}}},
+		{name: "duplicate target", mappings: []FieldMapping{{From: "user_id", To: "id"}, {From: "merchant.id", To: "id"}}},
+		{name: "extra target", mappings: []FieldMapping{{From: "user_id", To: "extra.user"}}, keepExtra: true},
+		{name: "stored by default", mappings: []FieldMapping{{From: "merchant.category", To: "category"}}},
+		{name: "nested under stored", mappings: []FieldMapping{{From: "merchant.id", To: "location.merchant"}}},
+		{name: "included target", include: []string{"user_id", "merchant.id"}, mappings: []FieldMapping{{From: "merchant.id", To: "user_id"}}},
+		{name: "nested target", mappings: []FieldMapping{{From: "user_id", To: "user"}, {From: "merchant.id", To: "user.merchant"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProjection(tt.include, nil, tt.mappings, tt.keepExtra); err == nil {
+				t.Error("NewProjection() succeeded, want an error")
+			}
+		})
+	}
+}
+
+// the decimal coercion keeps the exact value
+func TestProjectionDecimal(t *testing.T) {
+	p, _ := NewProjection([]string{"merchant.score"}, nil, []FieldMapping{{From: "merchant.score", Type: FieldDecimal}}, false)
+	got, _ := p.Project(decodeDoc(t), "INR", TransformOptions{})
+	want, _ := primitive.ParseDecimal128("4")
+	if score := got["merchant"].(map[string]any)["score"]; score != want {
+		t.Errorf("merchant.score = %v, want %v", score, want)
+	}
+}
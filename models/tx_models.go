@@ -1,42 +1,132 @@
 package models
 
+import (
+	// Go Internal Packages
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+)
+
 type Transaction struct {
-	TxID            string  `json:"transaction_id"`
-	UserID          string  `json:"user_id"`
-	Amount          float32 `json:"amount"`
-	Currency        string  `json:"currency"`
-	TransactionType string  `json:"transaction_type"`
-	Status          string  `json:"status"`
-	Timestamp       string  `json:"timestamp"`
-	PaymentMethod   string  `json:"payment_method"`
-	CardNumber      string  `json:"card_number"`
-	BankName        string  `json:"bank_name"`
-	MerchantName    string  `json:"merchant_name"`
-	Location        string  `json:"location"`
-	Category        string  `json:"category"`
-	InvoiceNumber   string  `json:"invoice_number"`
-	Discount        float64 `json:"discount"`
-	IPAddress       string  `json:"ip_address"`
+	TxID            string `json:"transaction_id"`
+	UserID          string `json:"user_id"`
+	Amount          Amount `json:"amount"`
+	Currency        string `json:"currency"`
+	TransactionType string `json:"transaction_type"`
+	Status          string `json:"status"`
+	Timestamp       string `json:"timestamp"`
+	PaymentMethod   string `json:"payment_method"`
+	CardNumber      string `json:"card_number"`
+	BankName        string `json:"bank_name"`
+	MerchantName    string `json:"merchant_name"`
+	Location        string `json:"location"`
+	Category        string `json:"category"`
+	InvoiceNumber   string `json:"invoice_number"`
+	Discount        Amount `json:"discount"`
+	IPAddress       string `json:"ip_address"`
 }
 
+// MongoTransaction is the stored form of a transaction, Fields holds everything stored by the projection
+// next to the typed fields. StatusHistory is only kept by the lifecycle conflict policy.
 type MongoTransaction struct {
-	TxID            string  `json:"transaction_id" bson:"_id"`
-	Amount          float32 `json:"amount" bson:"amount"`
-	Currency        string  `json:"currency" bson:"currency"`
-	TransactionType string  `json:"transaction_type" bson:"transaction_type"`
-	Status          string  `json:"status" bson:"status"`
-	Timestamp       string  `json:"timestamp" bson:"timestamp"`
-	PaymentMethod   string  `json:"payment_method" bson:"payment_method"`
+	TxID          string         `json:"transaction_id" bson:"_id"`
+	Amount        MongoAmount    `json:"amount" bson:"amount"`
+	Timestamp     time.Time      `json:"timestamp" bson:"timestamp"`
+	Status        string         `json:"status" bson:"status"`
+	StatusHistory []StatusChange `json:"status_history,omitempty" bson:"status_history,omitempty"`
+	Fields        map[string]any `json:"fields" bson:",inline"`
+	Source        Source         `json:"source" bson:"source"`
+	IngestedAt    time.Time      `json:"ingested_at" bson:"ingested_at"`
+}
+
+// DefaultTimestampLayouts are accepted when no timestamp layouts are configured
+var DefaultTimestampLayouts = []string{time.RFC3339Nano}
+
+// TransformOptions decides how amounts are stored, which timestamp layouts are accepted and which fields
+// are stored, every field of a Transaction when Projection is nil
+type TransformOptions struct {
+	AmountFormat     AmountFormat
+	TimestampLayouts []string
+	Projection       *Projection
+}
+
+// Source records where a stored transaction was consumed from
+type Source struct {
+	Topic     string    `json:"topic" bson:"topic"`
+	Partition int32     `json:"partition" bson:"partition"`
+	Offset    int64     `json:"offset" bson:"offset"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
 }
 
-func (t *Transaction) Transform() MongoTransaction {
+// SourceOf returns the provenance of the given record
+func SourceOf(record Record) Source {
+	return Source{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+	}
+}
+
+// Transform converts the transaction into its stored form, converting the amount into the configured format,
+// parsing the timestamp with the first matching layout and projecting the fields besides the id, amount, timestamp
+// and status from the decoded document.
+// Returns errors.ValidationErrors listing every field which cannot be converted.
+func (t *Transaction) Transform(doc map[string]any, opts TransformOptions) (MongoTransaction, error) {
+	ve := errors.ValidationErrs()
+
+	amount, err := NewMongoAmount(t.Amount, t.Currency, opts.AmountFormat)
+	if err != nil {
+		ve.Add("amount", err.Error())
+	}
+
+	timestamp, ok := ParseTimestamp(t.Timestamp, opts.TimestampLayouts)
+	if !ok {
+		ve.Add("timestamp", "does not match any accepted layout")
+	}
+
+	projection := opts.Projection
+	if projection == nil {
+		projection = DefaultProjection
+	}
+	fields, failed := projection.Project(doc, t.Currency, opts)
+	for field, msg := range failed {
+		ve.Add(field, msg)
+	}
+
+	if err = ve.Err(); err != nil {
+		return MongoTransaction{}, err
+	}
 	return MongoTransaction{
-		TxID:            t.TxID,
-		Amount:          t.Amount,
-		Currency:        t.Currency,
-		TransactionType: t.TransactionType,
-		Status:          t.Status,
-		Timestamp:       t.Timestamp,
-		PaymentMethod:   t.PaymentMethod,
+		TxID:      t.TxID,
+		Amount:    amount,
+		Timestamp: timestamp,
+		Status:    t.Status,
+		Fields:    fields,
+	}, nil
+}
+
+// ParseTimestamp parses the timestamp with the first of the layouts it matches, in UTC. Layouts without
+// a zone are read as UTC. Falls back to DefaultTimestampLayouts when no layouts are given.
+func ParseTimestamp(value string, layouts []string) (time.Time, bool) {
+	if len(layouts) == 0 {
+		layouts = DefaultTimestampLayouts
+	}
+	for _, layout := range layouts {
+		if ts, err := time.Parse(layout, value); err == nil {
+			return ts.UTC(), true
+		}
 	}
+	return time.Time{}, false
+}
+
+// WriteResult reports how a batch of transactions was applied to the store.
+type WriteResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+
+	// Failed maps the index of each transaction that could not be written to its error.
+	Failed map[int]error
 }
@@ -0,0 +1,51 @@
+package models
+
+import (
+	// Go Internal Packages
+	"testing"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+)
+
+func TestTransformTimestampLayouts(t *testing.T) {
+	want := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		layouts   []string
+		timestamp string
+		valid     bool
+	}{
+		{name: "default rfc 3339", timestamp: "2025-01-01T05:30:00+05:30", valid: true},
+		{name: "default rejects other layouts", timestamp: "2025-01-01 00:00:00"},
+		{name: "configured layout", layouts: []string{"2006-01-02 15:04:05"}, timestamp: "2025-01-01 00:00:00", valid: true},
+		{name: "second configured layout", layouts: []string{time.RFC3339, "02/01/2006"}, timestamp: "01/01/2025", valid: true},
+		{name: "configured layouts only", layouts: []string{"2006-01-02 15:04:05"}, timestamp: "2025-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, err := ParseAmount("10.50")
+			if err != nil {
+				t.Fatalf("ParseAmount() error = %v", err)
+			}
+			tx := Transaction{TxID: "tx-1", Amount: amount, Currency: "INR", Timestamp: tt.timestamp}
+
+			stored, err := tx.Transform(decodeDoc(t), TransformOptions{TimestampLayouts: tt.layouts})
+			if !tt.valid {
+				var ve errors.ValidationErrors
+				if !errors.As(err, &ve) || len(ve) != 1 || ve[0].Field != "timestamp" {
+					t.Errorf("Transform() error = %v, want a timestamp error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if !stored.Timestamp.Equal(want) || stored.Timestamp.Location() != time.UTC {
+				t.Errorf("Transform() timestamp = %v, want %v", stored.Timestamp, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package mongodb
+
+import (
+	// Go Internal Packages
+	"context"
+
+	// Local Packages
+	breaker "tx-stream/breaker"
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+)
+
+// TxWriter writes batches of transactions, as TxRepository does
+type TxWriter interface {
+	UpsertTransactions(ctx context.Context, txs []models.MongoTransaction) (models.WriteResult, error)
+	UpsertTransactionsWithOffset(ctx context.Context, txs []models.MongoTransaction, offset models.PartitionOffset) (models.WriteResult, error)
+}
+
+// BreakerTxRepository guards a TxWriter with a circuit breaker, rejecting writes with
+// breaker.ErrOpen while mongo is considered unavailable.
+type BreakerTxRepository struct {
+	repo    TxWriter
+	breaker *breaker.Breaker
+}
+
+// NewBreakerTxRepository wraps the repository with the circuit breaker
+func NewBreakerTxRepository(repo TxWriter, b *breaker.Breaker) *BreakerTxRepository {
+	return &BreakerTxRepository{repo: repo, breaker: b}
+}
+
+// UpsertTransaction writes a single transaction unless the breaker is open
+func (r *BreakerTxRepository) UpsertTransaction(ctx context.Context, tx models.MongoTransaction) (models.WriteResult, error) {
+	return r.UpsertTransactions(ctx, []models.MongoTransaction{tx})
+}
+
+// UpsertTransactions writes the transactions unless the breaker is open
+func (r *BreakerTxRepository) UpsertTransactions(ctx context.Context, txs []models.MongoTransaction) (models.WriteResult, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return models.WriteResult{}, err
+	}
+	result, err := r.repo.UpsertTransactions(ctx, txs)
+	r.record(ctx, err)
+	return result, err
+}
+
+// UpsertTransactionsWithOffset writes the transactions along with the offset unless the breaker is open
+func (r *BreakerTxRepository) UpsertTransactionsWithOffset(ctx context.Context, txs []models.MongoTransaction, offset models.PartitionOffset) (models.WriteResult, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return models.WriteResult{}, err
+	}
+	result, err := r.repo.UpsertTransactionsWithOffset(ctx, txs, offset)
+	r.record(ctx, err)
+	return result, err
+}
+
+// record counts the outcome of a write. Rejected transactions and partial writes mean mongo is
+// reachable, and writes canceled by the caller say nothing about mongo, so neither count as failures.
+func (r *BreakerTxRepository) record(ctx context.Context, err error) {
+	switch {
+	case err == nil, errors.Is(err, ErrPartialWrite), errors.IsPermanent(err):
+		r.breaker.Success()
+	case ctx.Err() != nil:
+	default:
+		r.breaker.Failure(err)
+	}
+}
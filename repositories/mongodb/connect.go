@@ -3,21 +3,117 @@ package mongodb
 import (
 	// Go Internal Packages
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	// External Packages
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-// Connect connects to the mongodb server and returns the client.
-func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
-	// Set the server selection timeout to 5 seconds.
-	timeout := time.Second * 5
-	opts := &options.ClientOptions{ServerSelectionTimeout: &timeout}
+// Options configures the client and where transactions and offsets are stored. Zero values leave
+// the setting to the connection string, or to the driver's default.
+type Options struct {
+	Database          string
+	Collection        string
+	OffsetsCollection string
+
+	AppName        string
+	ReadPreference string
+	WriteConcern   WriteConcern
+	MaxPoolSize    uint64
+	MinPoolSize    uint64
+
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+}
+
+// WriteConcern is the acknowledgment requested for writes. W is "majority" or the number of members.
+type WriteConcern struct {
+	W        string
+	Journal  bool
+	WTimeout time.Duration
+}
+
+// Build returns the driver's write concern, failing when W is neither "majority" nor a number
+// or when no acknowledgment is requested along with journaling
+func (w WriteConcern) Build() (*writeconcern.WriteConcern, error) {
+	wc := &writeconcern.WriteConcern{WTimeout: w.WTimeout}
+	switch w.W {
+	case "":
+	case "majority":
+		wc.W = "majority"
+	default:
+		n, err := strconv.Atoi(w.W)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("w must be majority or a non-negative number, got %q", w.W)
+		}
+		wc.W = n
+	}
+	if w.Journal {
+		wc.Journal = &w.Journal
+	}
+	if !wc.Acknowledged() && w.Journal {
+		return nil, fmt.Errorf("journaling requires acknowledged writes")
+	}
+	return wc, nil
+}
+
+// ParseReadPreference returns the read preference of the mode, one of primary, primaryPreferred,
+// secondary, secondaryPreferred or nearest
+func ParseReadPreference(mode string) (*readpref.ReadPref, error) {
+	m, err := readpref.ModeFromString(mode)
+	if err != nil {
+		return nil, err
+	}
+	return readpref.New(m)
+}
+
+// Connect connects to the mongodb server and returns the client. The monitor, if any, is notified of every command.
+func Connect(ctx context.Context, uri string, opts Options, monitor *event.CommandMonitor) (*mongo.Client, error) {
+	clientOpts := options.Client().ApplyURI(uri).SetMonitor(monitor)
+
+	// the options below override the ones in the connection string
+	if opts.AppName != "" {
+		clientOpts.SetAppName(opts.AppName)
+	}
+	if opts.ReadPreference != "" {
+		rp, err := ParseReadPreference(opts.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts.SetReadPreference(rp)
+	}
+	if opts.WriteConcern != (WriteConcern{}) {
+		wc, err := opts.WriteConcern.Build()
+		if err != nil {
+			return nil, err
+		}
+		clientOpts.SetWriteConcern(wc)
+	}
+	if opts.MaxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(opts.MaxPoolSize)
+	}
+	if opts.MinPoolSize > 0 {
+		clientOpts.SetMinPoolSize(opts.MinPoolSize)
+	}
+	if opts.ConnectTimeout > 0 {
+		clientOpts.SetConnectTimeout(opts.ConnectTimeout)
+	}
+	if opts.ServerSelectionTimeout > 0 {
+		clientOpts.SetServerSelectionTimeout(opts.ServerSelectionTimeout)
+	}
+	if opts.SocketTimeout > 0 {
+		clientOpts.SetSocketTimeout(opts.SocketTimeout)
+	}
 
 	// Create a new MongoDB client with the provided URI and options.
-	client, err := mongo.Connect(ctx, opts.ApplyURI(uri))
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return nil, err
 	}
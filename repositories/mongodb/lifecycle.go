@@ -0,0 +1,206 @@
+package mongodb
+
+import (
+	// Go Internal Packages
+	"context"
+	"fmt"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lifecycleState is where a transaction stands in its lifecycle while the events of a batch are applied
+type lifecycleState struct {
+	stored       bool
+	storedStatus string
+	status       string
+	timestamp    time.Time
+	applied      []models.StatusChange
+
+	// latest is the last applied event, history the changes applied from the batch and origins their indexes
+	latest  models.MongoTransaction
+	history []models.StatusChange
+	origins []int
+}
+
+// lifecycleModels builds the writes moving the transactions along the lifecycle from their stored status.
+// The events of a transaction are applied in order and folded into a single write, so the unordered bulk
+// write cannot reorder them. Events already in the status history or repeating the current status are skipped
+// as duplicates, so redelivered events are not dead-lettered. Events which cannot start a transaction, are
+// older than its current status or move it along a transition which is not allowed are rejected with
+// errors.Invalid. The writes only apply while the stored status is still the one read, otherwise they fail
+// with a duplicate id. Returns the indexes of the events of each write.
+func (r *TxRepository) lifecycleModels(ctx context.Context, txs []models.MongoTransaction) ([]mongo.WriteModel, [][]int, map[int]error, error) {
+	stored, err := r.storedStatuses(ctx, txs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return r.lifecycleWrites(txs, stored)
+}
+
+// lifecycleWrites builds the writes of lifecycleModels from the stored transactions by id
+func (r *TxRepository) lifecycleWrites(txs []models.MongoTransaction, stored map[string]models.MongoTransaction) ([]mongo.WriteModel, [][]int, map[int]error, error) {
+	var order []string
+	states := make(map[string]*lifecycleState)
+	rejected := make(map[int]error)
+	for idx, tx := range txs {
+		state, ok := states[tx.TxID]
+		if !ok {
+			state = &lifecycleState{}
+			if current, found := stored[tx.TxID]; found {
+				state.stored, state.storedStatus = true, current.Status
+				state.status, state.timestamp = current.Status, current.Timestamp
+				state.applied = current.StatusHistory
+			}
+			states[tx.TxID] = state
+			order = append(order, tx.TxID)
+		}
+
+		if state.isApplied(tx) {
+			continue
+		}
+		if reason := r.transitionError(state, tx); reason != "" {
+			rejected[idx] = errors.E(errors.Invalid, reason)
+			continue
+		}
+		if state.status == tx.Status && !r.lifecycle.CanMove(tx.Status, tx.Status) {
+			continue
+		}
+		state.status, state.timestamp, state.latest = tx.Status, tx.Timestamp, tx
+		state.history = append(state.history, models.ChangeOf(tx))
+		state.applied = append(state.applied, models.ChangeOf(tx))
+		state.origins = append(state.origins, idx)
+	}
+
+	var writes []mongo.WriteModel
+	var origins [][]int
+	for _, id := range order {
+		state := states[id]
+		if len(state.history) == 0 {
+			continue
+		}
+
+		if !state.stored {
+			doc := state.latest
+			doc.StatusHistory = state.history
+			writes = append(writes, mongo.NewInsertOneModel().SetDocument(doc))
+		} else {
+			fields, err := setFields(state.latest)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			update := bson.M{"$set": fields, "$push": bson.M{"status_history": bson.M{"$each": state.history}}}
+			filter := bson.M{"_id": id, "status": state.storedStatus}
+			writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+		}
+		origins = append(origins, state.origins)
+	}
+	return writes, origins, rejected, nil
+}
+
+// lifecycleCounts counts the events written by the lifecycle writes which did not fail. The first event of a
+// new transaction is inserted and the events folded after it update it, like every event of an update.
+func lifecycleCounts(writes []mongo.WriteModel, origins [][]int, failed map[int]error) (inserted, updated int) {
+	for idx, write := range writes {
+		if _, ok := failed[origins[idx][0]]; ok {
+			continue
+		}
+		updated += len(origins[idx])
+		if _, ok := write.(*mongo.InsertOneModel); ok {
+			inserted++
+			updated--
+		}
+	}
+	return inserted, updated
+}
+
+// isApplied reports whether the event is already in the status history of the transaction
+func (s *lifecycleState) isApplied(tx models.MongoTransaction) bool {
+	for _, change := range s.applied {
+		if change.Status == tx.Status && storedTime(change.Timestamp).Equal(storedTime(tx.Timestamp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionError returns why the event cannot be applied to the transaction, empty when it can
+func (r *TxRepository) transitionError(state *lifecycleState, tx models.MongoTransaction) string {
+	switch {
+	case state.status == "" && !r.lifecycle.CanStart(tx.Status):
+		return fmt.Sprintf("transaction cannot start in status %s", tx.Status)
+	case state.status == "":
+		return ""
+	case storedTime(tx.Timestamp).Before(storedTime(state.timestamp)):
+		return fmt.Sprintf("out of order status %s at %s, the transaction is %s since %s", tx.Status,
+			tx.Timestamp.Format(time.RFC3339Nano), state.status, state.timestamp.Format(time.RFC3339Nano))
+	case state.status == tx.Status:
+		return ""
+	case !r.lifecycle.CanMove(state.status, tx.Status):
+		return fmt.Sprintf("status transition from %s to %s is not allowed", state.status, tx.Status)
+	default:
+		return ""
+	}
+}
+
+// storedTime truncates the time to the millisecond precision of the dates stored by mongo
+func storedTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond)
+}
+
+// storedStatuses returns the status, timestamp and status history of the stored transactions among txs by id
+func (r *TxRepository) storedStatuses(ctx context.Context, txs []models.MongoTransaction) (map[string]models.MongoTransaction, error) {
+	ids := make([]string, len(txs))
+	for idx, tx := range txs {
+		ids[idx] = tx.TxID
+	}
+
+	collection := r.client.Database(r.database).Collection(r.collection)
+	projection := options.Find().SetProjection(bson.M{
+		"status":                   1,
+		"timestamp":                1,
+		"status_history.status":    1,
+		"status_history.timestamp": 1,
+	})
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []models.MongoTransaction
+	if err = cursor.All(ctx, &current); err != nil {
+		return nil, err
+	}
+	stored := make(map[string]models.MongoTransaction, len(current))
+	for _, tx := range current {
+		stored[tx.TxID] = tx
+	}
+	return stored, nil
+}
+
+// setFields returns the fields of the transaction to set on the stored one, all but the id and history
+func setFields(tx models.MongoTransaction) (bson.D, error) {
+	data, err := bson.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	fields := make(bson.D, 0, len(doc))
+	for _, field := range doc {
+		if field.Key != "_id" && field.Key != "status_history" {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
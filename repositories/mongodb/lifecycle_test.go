@@ -0,0 +1,124 @@
+package mongodb
+
+import (
+	// Go Internal Packages
+	"strings"
+	"testing"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var lifecycleStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// statusEvent is the transaction moving to the status the given minutes after lifecycleStart
+func statusEvent(id, status string, minutes int) models.MongoTransaction {
+	return models.MongoTransaction{TxID: id, Status: status, Timestamp: lifecycleStart.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func newLifecycleRepository(t *testing.T) *TxRepository {
+	t.Helper()
+	lifecycle, err := models.NewLifecycle([]string{"pending"}, map[string][]string{
+		"pending": {"success", "failed"},
+		"success": {"refunded"},
+	})
+	if err != nil {
+		t.Fatalf("NewLifecycle() error = %v", err)
+	}
+	r, err := NewTxRepository(nil, Options{}, Lifecycle, lifecycle)
+	if err != nil {
+		t.Fatalf("NewTxRepository() error = %v", err)
+	}
+	return r
+}
+
+func TestLifecycleWrites(t *testing.T) {
+	r := newLifecycleRepository(t)
+
+	pending := statusEvent("tx-2", "pending", 0)
+	pending.StatusHistory = []models.StatusChange{models.ChangeOf(pending)}
+	stored := map[string]models.MongoTransaction{"tx-2": pending, "tx-3": statusEvent("tx-3", "refunded", 5)}
+
+	txs := []models.MongoTransaction{
+		statusEvent("tx-1", "pending", 0),  // new transaction
+		statusEvent("tx-1", "success", 1),  // folded into the insert of tx-1
+		statusEvent("tx-2", "pending", 0),  // redelivered, already in the history
+		statusEvent("tx-2", "success", 2),  // moves the stored transaction
+		statusEvent("tx-2", "success", 3),  // repeats the current status
+		statusEvent("tx-3", "success", 6),  // refunded is final
+		statusEvent("tx-4", "success", 0),  // cannot start as success
+		statusEvent("tx-1", "pending", -1), // older than the current status
+	}
+	writes, origins, rejected, err := r.lifecycleWrites(txs, stored)
+	if err != nil {
+		t.Fatalf("lifecycleWrites() error = %v", err)
+	}
+
+	if len(writes) != 2 {
+		t.Fatalf("lifecycleWrites() = %d writes, want 2", len(writes))
+	}
+	insert, ok := writes[0].(*mongo.InsertOneModel)
+	if !ok {
+		t.Fatalf("write of tx-1 is %T, want an insert", writes[0])
+	}
+	if doc := insert.Document.(models.MongoTransaction); doc.Status != "success" || len(doc.StatusHistory) != 2 {
+		t.Errorf("tx-1 inserted as %s with history %v, want success after pending", doc.Status, doc.StatusHistory)
+	}
+	update, ok := writes[1].(*mongo.UpdateOneModel)
+	if !ok {
+		t.Fatalf("write of tx-2 is %T, want an update", writes[1])
+	}
+	if filter := update.Filter.(bson.M); filter["status"] != "pending" {
+		t.Errorf("tx-2 updated with filter %v, want it conditional on the stored status", filter)
+	}
+	if len(origins[0]) != 2 || len(origins[1]) != 1 || origins[1][0] != 3 {
+		t.Errorf("origins = %v, want [[0 1] [3]]", origins)
+	}
+
+	wantRejected := map[int]string{5: "from refunded to success", 6: "cannot start in status success", 7: "out of order"}
+	if len(rejected) != len(wantRejected) {
+		t.Fatalf("rejected = %v, want %v", rejected, wantRejected)
+	}
+	for idx, reason := range wantRejected {
+		var appErr *errors.Error
+		if !errors.As(rejected[idx], &appErr) || !errors.IsPermanent(rejected[idx]) || !strings.Contains(appErr.Message, reason) {
+			t.Errorf("event %d rejected with %v, want a permanent error containing %q", idx, rejected[idx], reason)
+		}
+	}
+
+	// the redelivered and repeated events are skipped
+	inserted, updated := lifecycleCounts(writes, origins, rejected)
+	if skipped := len(txs) - inserted - updated - len(rejected); inserted != 1 || updated != 2 || skipped != 2 {
+		t.Errorf("inserted %d, updated %d, skipped %d, want 1, 2 and 2", inserted, updated, skipped)
+	}
+	// the events folded into a failed write fail with it
+	rejected[0], rejected[1] = errors.E(errors.Internal, "write failed"), errors.E(errors.Internal, "write failed")
+	if inserted, updated = lifecycleCounts(writes, origins, rejected); inserted != 0 || updated != 1 {
+		t.Errorf("inserted %d, updated %d after the insert of tx-1 failed, want 0 and 1", inserted, updated)
+	}
+}
+
+func TestLifecycleWritesMillisecondHistory(t *testing.T) {
+	r := newLifecycleRepository(t)
+
+	// mongo keeps dates to the millisecond, a redelivered event still matches its history entry
+	pending := statusEvent("tx-1", "pending", 0)
+	pending.Timestamp = pending.Timestamp.Add(1500 * time.Microsecond)
+	storedPending := pending
+	storedPending.Timestamp = storedPending.Timestamp.Truncate(time.Millisecond)
+	storedPending.StatusHistory = []models.StatusChange{models.ChangeOf(storedPending)}
+	storedSuccess := statusEvent("tx-1", "success", 1)
+	storedSuccess.StatusHistory = append(storedPending.StatusHistory, models.ChangeOf(storedSuccess))
+
+	writes, _, rejected, err := r.lifecycleWrites([]models.MongoTransaction{pending},
+		map[string]models.MongoTransaction{"tx-1": storedSuccess})
+	if err != nil || len(writes) != 0 || len(rejected) != 0 {
+		t.Errorf("lifecycleWrites() = %d writes, rejected %v, error %v, want the event skipped", len(writes), rejected, err)
+	}
+}
@@ -0,0 +1,306 @@
+package mongodb
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	// Local Packages
+	errors "tx-stream/errors"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Schema declares the indexes of the transactions collection and how the collection is created when it
+// does not exist, as a time-series collection when TimeSeries.TimeField is set and with a JSON-schema
+// validator when Validator.Schema is set.
+type Schema struct {
+	Indexes    []Index
+	TimeSeries TimeSeries
+	Validator  Validator
+}
+
+// Index is an index on the fields of Keys in order, descending for the fields prefixed with '-'.
+// Named after its keys like mongo does when Name is empty.
+type Index struct {
+	Name   string
+	Keys   []string
+	Unique bool
+}
+
+type TimeSeries struct {
+	TimeField   string
+	MetaField   string
+	Granularity string
+}
+
+// Validator is a $jsonSchema validator, Level and Action are left to the server's defaults when empty
+type Validator struct {
+	Schema bson.D
+	Level  string
+	Action string
+}
+
+// SchemaReport lists what EnsureSchema created and how the collection drifted from the schema
+type SchemaReport struct {
+	CreatedCollection bool
+	CreatedIndexes    []string
+	// MissingIndexes are declared but do not exist, only reported when the schema is not applied
+	MissingIndexes []string
+	// Drift describes the declarations which differ from what exists, these are never changed automatically
+	Drift []string
+	// UndeclaredIndexes exist but are not declared
+	UndeclaredIndexes []string
+}
+
+// Drifted reports whether anything exists differently from the schema or is missing
+func (r SchemaReport) Drifted() bool {
+	return len(r.MissingIndexes) > 0 || len(r.Drift) > 0 || len(r.UndeclaredIndexes) > 0
+}
+
+// LoadValidator reads the $jsonSchema document of a validator from the extended JSON file at path
+func LoadValidator(path string) (bson.D, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema bson.D
+	if err = bson.UnmarshalExtJSON(data, false, &schema); err != nil {
+		return nil, fmt.Errorf("invalid validator schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// IndexName returns the name of the index, named after its keys when it has no name
+func (i Index) IndexName() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	parts := make([]string, 0, 2*len(i.Keys))
+	for _, key := range i.keys() {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// keys returns the keys document of the index
+func (i Index) keys() bson.D {
+	keys := make(bson.D, len(i.Keys))
+	for idx, field := range i.Keys {
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			keys[idx] = bson.E{Key: name, Value: -1}
+		} else {
+			keys[idx] = bson.E{Key: field, Value: 1}
+		}
+	}
+	return keys
+}
+
+// validateSchema rejects schemas with duplicate index names, and time-series collections under the
+// lifecycle conflict policy which has to update the stored transactions
+func (r *TxRepository) validateSchema(schema Schema) error {
+	names := make(map[string]bool, len(schema.Indexes))
+	for _, index := range schema.Indexes {
+		name := index.IndexName()
+		if names[name] {
+			return errors.E(errors.Invalid, fmt.Sprintf("duplicate index %s", name))
+		}
+		names[name] = true
+	}
+	if r.policy == Lifecycle && schema.TimeSeries.TimeField != "" {
+		return errors.E(errors.Invalid, "the lifecycle conflict policy cannot update a time-series collection")
+	}
+	return nil
+}
+
+// EnsureSchema compares the transactions collection with the schema. When apply is set the collection and
+// the missing indexes are created, otherwise they are only reported. Indexes and collection options which
+// differ from the schema are reported as drift and left untouched.
+func (r *TxRepository) EnsureSchema(ctx context.Context, schema Schema, apply bool) (SchemaReport, error) {
+	var report SchemaReport
+	if err := r.validateSchema(schema); err != nil {
+		return report, err
+	}
+	db := r.client.Database(r.database)
+
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": r.collection})
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case len(specs) > 0:
+		report.Drift = collectionDrift(specs[0], schema)
+	case apply:
+		if err = db.CreateCollection(ctx, r.collection, createOptions(schema)); err != nil {
+			return report, fmt.Errorf("cannot create collection %s: %w", r.collection, err)
+		}
+		report.CreatedCollection = true
+	default:
+		report.Drift = append(report.Drift, fmt.Sprintf("collection %s does not exist", r.collection))
+	}
+
+	existing := make(map[string]*mongo.IndexSpecification)
+	if len(specs) > 0 {
+		indexes, err := db.Collection(r.collection).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, index := range indexes {
+			existing[index.Name] = index
+		}
+	}
+
+	var missing []mongo.IndexModel
+	declared := make(map[string]bool, len(schema.Indexes))
+	for _, index := range schema.Indexes {
+		name := index.IndexName()
+		declared[name] = true
+		current, ok := existing[name]
+		if !ok {
+			report.MissingIndexes = append(report.MissingIndexes, name)
+			missing = append(missing, mongo.IndexModel{
+				Keys:    index.keys(),
+				Options: options.Index().SetName(name).SetUnique(index.Unique),
+			})
+			continue
+		}
+		if drift := indexDrift(current, index); drift != "" {
+			report.Drift = append(report.Drift, drift)
+		}
+	}
+	for name := range existing {
+		if !declared[name] && name != "_id_" {
+			report.UndeclaredIndexes = append(report.UndeclaredIndexes, name)
+		}
+	}
+	slices.Sort(report.UndeclaredIndexes)
+
+	if apply && len(missing) > 0 {
+		if _, err = db.Collection(r.collection).Indexes().CreateMany(ctx, missing); err != nil {
+			return report, fmt.Errorf("cannot create indexes on %s: %w", r.collection, err)
+		}
+		report.CreatedIndexes, report.MissingIndexes = report.MissingIndexes, nil
+	}
+	return report, nil
+}
+
+// createOptions returns the options the collection is created with
+func createOptions(schema Schema) *options.CreateCollectionOptions {
+	opts := options.CreateCollection()
+	if ts := schema.TimeSeries; ts.TimeField != "" {
+		tsOpts := options.TimeSeries().SetTimeField(ts.TimeField)
+		if ts.MetaField != "" {
+			tsOpts.SetMetaField(ts.MetaField)
+		}
+		if ts.Granularity != "" {
+			tsOpts.SetGranularity(ts.Granularity)
+		}
+		opts.SetTimeSeriesOptions(tsOpts)
+	}
+	if v := schema.Validator; v.Schema != nil {
+		opts.SetValidator(bson.D{{Key: "$jsonSchema", Value: v.Schema}})
+		if v.Level != "" {
+			opts.SetValidationLevel(v.Level)
+		}
+		if v.Action != "" {
+			opts.SetValidationAction(v.Action)
+		}
+	}
+	return opts
+}
+
+// collectionDrift describes how the options of the existing collection differ from the schema
+func collectionDrift(spec *mongo.CollectionSpecification, schema Schema) []string {
+	var current struct {
+		TimeSeries struct {
+			TimeField   string `bson:"timeField"`
+			MetaField   string `bson:"metaField"`
+			Granularity string `bson:"granularity"`
+		} `bson:"timeseries"`
+		Validator struct {
+			Schema bson.Raw `bson:"$jsonSchema"`
+		} `bson:"validator"`
+		ValidationLevel  string `bson:"validationLevel"`
+		ValidationAction string `bson:"validationAction"`
+	}
+	if err := bson.Unmarshal(spec.Options, &current); err != nil {
+		return []string{fmt.Sprintf("cannot read options of collection %s: %v", spec.Name, err)}
+	}
+
+	var drift []string
+	want, got := schema.TimeSeries, current.TimeSeries
+	switch {
+	case want.TimeField == "" && got.TimeField != "":
+		drift = append(drift, fmt.Sprintf("collection %s is a time-series collection", spec.Name))
+	case want.TimeField != "" && got.TimeField == "":
+		drift = append(drift, fmt.Sprintf("collection %s is not a time-series collection", spec.Name))
+	case want.TimeField != got.TimeField || want.MetaField != got.MetaField ||
+		(want.Granularity != "" && want.Granularity != got.Granularity):
+		drift = append(drift, fmt.Sprintf("collection %s is a time-series collection on %s/%s/%s", spec.Name,
+			got.TimeField, got.MetaField, got.Granularity))
+	}
+
+	validator := schema.Validator
+	switch {
+	case validator.Schema == nil && current.Validator.Schema != nil:
+		drift = append(drift, fmt.Sprintf("collection %s has an undeclared validator", spec.Name))
+	case validator.Schema != nil && current.Validator.Schema == nil:
+		drift = append(drift, fmt.Sprintf("collection %s has no validator", spec.Name))
+	case validator.Schema != nil:
+		if want, err := bson.Marshal(validator.Schema); err != nil || !bytes.Equal(want, current.Validator.Schema) {
+			drift = append(drift, fmt.Sprintf("collection %s has a different validator schema", spec.Name))
+		}
+		if validator.Level != "" && validator.Level != current.ValidationLevel {
+			drift = append(drift, fmt.Sprintf("collection %s has validation level %s", spec.Name, current.ValidationLevel))
+		}
+		if validator.Action != "" && validator.Action != current.ValidationAction {
+			drift = append(drift, fmt.Sprintf("collection %s has validation action %s", spec.Name, current.ValidationAction))
+		}
+	}
+	return drift
+}
+
+// indexDrift describes how the existing index differs from its declaration, empty when it does not
+func indexDrift(current *mongo.IndexSpecification, index Index) string {
+	elems, err := current.KeysDocument.Elements()
+	if err != nil {
+		return fmt.Sprintf("cannot read keys of index %s: %v", current.Name, err)
+	}
+	keys := make([]string, len(elems))
+	for idx, elem := range elems {
+		keys[idx] = elem.Key()
+		if descending(elem.Value()) {
+			keys[idx] = "-" + elem.Key()
+		}
+	}
+	if !slices.Equal(keys, index.Keys) {
+		return fmt.Sprintf("index %s is on %s instead of %s", current.Name, strings.Join(keys, ","),
+			strings.Join(index.Keys, ","))
+	}
+	if unique := current.Unique != nil && *current.Unique; unique != index.Unique {
+		return fmt.Sprintf("index %s has unique %t instead of %t", current.Name, unique, index.Unique)
+	}
+	return ""
+}
+
+// descending reports whether the direction of an index key is descending, directions are stored as int32,
+// int64 or double depending on the client which created the index
+func descending(direction bson.RawValue) bool {
+	if v, ok := direction.Int32OK(); ok {
+		return v < 0
+	}
+	if v, ok := direction.Int64OK(); ok {
+		return v < 0
+	}
+	if v, ok := direction.DoubleOK(); ok {
+		return v < 0
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package mongodb
+
+import (
+	// Go Internal Packages
+	"strings"
+	"testing"
+
+	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIndexName(t *testing.T) {
+	tests := []struct {
+		index Index
+		want  string
+	}{
+		{Index{Keys: []string{"user_id", "timestamp"}}, "user_id_1_timestamp_1"},
+		{Index{Keys: []string{"merchant_name", "-timestamp"}}, "merchant_name_1_timestamp_-1"},
+		{Index{Name: "by_status", Keys: []string{"status"}}, "by_status"},
+	}
+	for _, tt := range tests {
+		if got := tt.index.IndexName(); got != tt.want {
+			t.Errorf("IndexName() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestIndexDrift(t *testing.T) {
+	spec := func(keys bson.D, unique bool) *mongo.IndexSpecification {
+		raw, _ := bson.Marshal(keys)
+		return &mongo.IndexSpecification{Name: "idx", KeysDocument: raw, Unique: &unique}
+	}
+
+	tests := []struct {
+		name    string
+		current *mongo.IndexSpecification
+		index   Index
+		drifted bool
+	}{
+		{"same keys", spec(bson.D{{Key: "user_id", Value: int32(1)}, {Key: "timestamp", Value: -1.0}}, false),
+			Index{Keys: []string{"user_id", "-timestamp"}}, false},
+		{"other direction", spec(bson.D{{Key: "status", Value: int64(-1)}}, false),
+			Index{Keys: []string{"status"}}, true},
+		{"other order", spec(bson.D{{Key: "timestamp", Value: 1}, {Key: "user_id", Value: 1}}, false),
+			Index{Keys: []string{"user_id", "timestamp"}}, true},
+		{"not unique", spec(bson.D{{Key: "status", Value: 1}}, false),
+			Index{Keys: []string{"status"}, Unique: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if drift := indexDrift(tt.current, tt.index); (drift != "") != tt.drifted {
+				t.Errorf("indexDrift() = %q, want drifted %v", drift, tt.drifted)
+			}
+		})
+	}
+}
+
+func TestCollectionDrift(t *testing.T) {
+	validator := bson.D{{Key: "required", Value: bson.A{"amount"}}}
+	spec := func(options bson.D) *mongo.CollectionSpecification {
+		raw, _ := bson.Marshal(options)
+		return &mongo.CollectionSpecification{Name: "transactions", Options: raw}
+	}
+
+	tests := []struct {
+		name    string
+		options bson.D
+		schema  Schema
+		want    []string
+	}{
+		{"plain collection", bson.D{}, Schema{}, nil},
+		{"time-series", bson.D{{Key: "timeseries", Value: bson.D{{Key: "timeField", Value: "timestamp"},
+			{Key: "granularity", Value: "seconds"}}}},
+			Schema{TimeSeries: TimeSeries{TimeField: "timestamp"}}, nil},
+		{"not time-series", bson.D{}, Schema{TimeSeries: TimeSeries{TimeField: "timestamp"}},
+			[]string{"is not a time-series collection"}},
+		{"validator", bson.D{{Key: "validator", Value: bson.D{{Key: "$jsonSchema", Value: validator}}},
+			{Key: "validationLevel", Value: "strict"}},
+			Schema{Validator: Validator{Schema: validator, Level: "strict"}}, nil},
+		{"other validator", bson.D{{Key: "validator", Value: bson.D{{Key: "$jsonSchema", Value: bson.D{}}}},
+			{Key: "validationLevel", Value: "strict"}},
+			Schema{Validator: Validator{Schema: validator, Level: "moderate"}},
+			[]string{"different validator schema", "validation level strict"}},
+		{"missing validator", bson.D{}, Schema{Validator: Validator{Schema: validator}}, []string{"has no validator"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift := collectionDrift(spec(tt.options), tt.schema)
+			if len(drift) != len(tt.want) {
+				t.Fatalf("collectionDrift() = %v, want %v", drift, tt.want)
+			}
+			for idx, want := range tt.want {
+				if !strings.Contains(drift[idx], want) {
+					t.Errorf("collectionDrift() = %v, want %v", drift, tt.want)
+				}
+			}
+		})
+	}
+}
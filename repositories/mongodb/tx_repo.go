@@ -3,44 +3,240 @@ package mongodb
 import (
 	// Go Internal Packages
 	"context"
+	"fmt"
+	"time"
 
 	// Local Packages
+	errors "tx-stream/errors"
 	models "tx-stream/models"
 
 	// External Packages
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ConflictPolicy decides what happens when a transaction with an existing transaction_id is written again.
+type ConflictPolicy string
+
+const (
+	KeepFirst      ConflictPolicy = "keep_first"      // The stored transaction is never overwritten
+	LastWriteWins  ConflictPolicy = "last_write_wins" // The latest write replaces the stored transaction
+	NewerTimestamp ConflictPolicy = "newer_timestamp" // Replaced only if the incoming timestamp is newer
+	Lifecycle      ConflictPolicy = "lifecycle"       // Updated only along the allowed status transitions
+)
+
+// ErrPartialWrite aborts a multi-document transaction when some of its transactions could not be written.
+var ErrPartialWrite = errors.NewError("some transactions could not be written")
+
+// IsValid reports whether the policy is one of the known conflict policies
+func (p ConflictPolicy) IsValid() bool {
+	switch p {
+	case KeepFirst, LastWriteWins, NewerTimestamp, Lifecycle:
+		return true
+	default:
+		return false
+	}
+}
+
 type TxRepository struct {
-	client     *mongo.Client
-	database   string
-	collection string
+	client            *mongo.Client
+	database          string
+	collection        string
+	offsetsCollection string
+	policy            ConflictPolicy
+	lifecycle         *models.Lifecycle
 }
 
-func NewTxRepository(client *mongo.Client) *TxRepository {
+// NewTxRepository creates a repository storing transactions and offsets in the database and collections of opts.
+// The lifecycle is required by the Lifecycle conflict policy.
+func NewTxRepository(client *mongo.Client, opts Options, policy ConflictPolicy, lifecycle *models.Lifecycle) (*TxRepository, error) {
+	if !policy.IsValid() {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("unknown conflict policy %q", policy))
+	}
+	if policy == Lifecycle && lifecycle == nil {
+		return nil, errors.E(errors.Invalid, "the lifecycle conflict policy requires a lifecycle")
+	}
 	return &TxRepository{
-		client:     client,
-		database:   "flipkart-db",
-		collection: "transactions",
+		client:            client,
+		database:          opts.Database,
+		collection:        opts.Collection,
+		offsetsCollection: opts.OffsetsCollection,
+		policy:            policy,
+		lifecycle:         lifecycle,
+	}, nil
+}
+
+// UpsertTransaction writes a single transaction into the database according to the conflict policy
+func (r *TxRepository) UpsertTransaction(ctx context.Context, tx models.MongoTransaction) (models.WriteResult, error) {
+	return r.UpsertTransactions(ctx, []models.MongoTransaction{tx})
+}
+
+// UpsertTransactions writes a batch of transactions into the database with an unordered bulk write of
+// upserts keyed on the transaction id, so redelivered duplicates never fail the batch. Transactions
+// rejected by the server, or by the lifecycle, are reported in WriteResult.Failed while the rest of
+// the batch is still written.
+func (r *TxRepository) UpsertTransactions(ctx context.Context, txs []models.MongoTransaction) (models.WriteResult, error) {
+	if len(txs) == 0 {
+		return models.WriteResult{}, nil
+	}
+
+	var writes []mongo.WriteModel
+	var origins [][]int
+	var result models.WriteResult
+	if r.policy == Lifecycle {
+		var err error
+		if writes, origins, result.Failed, err = r.lifecycleModels(ctx, txs); err != nil {
+			return models.WriteResult{}, err
+		}
+	} else {
+		writes = make([]mongo.WriteModel, len(txs))
+		origins = make([][]int, len(txs))
+		for idx, tx := range txs {
+			writes[idx] = r.upsertModel(tx)
+			origins[idx] = []int{idx}
+		}
+	}
+
+	var res *mongo.BulkWriteResult
+	if len(writes) > 0 {
+		collection := r.client.Database(r.database).Collection(r.collection)
+		var err error
+		res, err = collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+
+		var bwe mongo.BulkWriteException
+		switch {
+		case err == nil:
+		case errors.As(err, &bwe) && bwe.WriteConcernError == nil && len(bwe.WriteErrors) > 0:
+			if result.Failed == nil {
+				result.Failed = make(map[int]error, len(bwe.WriteErrors))
+			}
+			for _, we := range bwe.WriteErrors {
+				for _, idx := range origins[we.Index] {
+					result.Failed[idx] = r.classifyWriteError(we.WriteError)
+				}
+			}
+		default:
+			return models.WriteResult{}, err
+		}
+	}
+
+	switch {
+	case r.policy == Lifecycle:
+		result.Inserted, result.Updated = lifecycleCounts(writes, origins, result.Failed)
+	case res != nil:
+		result.Inserted = int(res.InsertedCount + res.UpsertedCount)
+		result.Updated = int(res.ModifiedCount)
 	}
+	result.Skipped = len(txs) - result.Inserted - result.Updated - len(result.Failed)
+	return result, nil
 }
 
-// InsertTransaction inserts a single transaction into the database
-func (r *TxRepository) InsertTransaction(ctx context.Context, tx models.MongoTransaction) error {
-	collection := r.client.Database(r.database).Collection(r.collection)
-	_, err := collection.InsertOne(ctx, tx)
+// UpsertTransactionsWithOffset writes a batch of transactions and stores the partition offset
+// in a single multi-document transaction, so either both are persisted or neither is. If any
+// transaction is rejected nothing is persisted and ErrPartialWrite is returned along with the
+// rejected transactions in WriteResult.Failed.
+func (r *TxRepository) UpsertTransactionsWithOffset(ctx context.Context, txs []models.MongoTransaction, offset models.PartitionOffset) (models.WriteResult, error) {
+	session, err := r.client.StartSession()
 	if err != nil {
-		return err
+		return models.WriteResult{}, err
 	}
-	return nil
+	defer session.EndSession(ctx)
+
+	var result models.WriteResult
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var err error
+		if result, err = r.UpsertTransactions(sc, txs); err != nil {
+			return nil, err
+		}
+		if len(result.Failed) > 0 {
+			return nil, ErrPartialWrite
+		}
+		return nil, r.SaveOffset(sc, offset)
+	})
+	if errors.Is(err, ErrPartialWrite) {
+		return models.WriteResult{Failed: result.Failed}, err
+	}
+	if err != nil {
+		return models.WriteResult{}, err
+	}
+	return result, nil
+}
+
+// upsertModel builds the write for a single transaction according to the conflict policy
+func (r *TxRepository) upsertModel(tx models.MongoTransaction) mongo.WriteModel {
+	filter := bson.M{"_id": tx.TxID}
+	switch r.policy {
+	case LastWriteWins:
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(tx).SetUpsert(true)
+	case NewerTimestamp:
+		// keep the stored document when its timestamp is newer, otherwise replace it with the incoming one
+		update := mongo.Pipeline{{{Key: "$replaceWith", Value: bson.M{
+			"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$timestamp", tx.Timestamp}},
+				"$$ROOT",
+				bson.M{"$literal": tx},
+			},
+		}}}}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+	default:
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{"$setOnInsert": tx}).SetUpsert(true)
+	}
+}
+
+// SaveOffset stores the next offset to consume for the given group, topic and partition. The stored offset
+// only moves forward, so a redelivered batch cannot rewind it.
+func (r *TxRepository) SaveOffset(ctx context.Context, offset models.PartitionOffset) error {
+	collection := r.client.Database(r.database).Collection(r.offsetsCollection)
+	filter := bson.M{"_id": offsetID(offset.Group, offset.Topic, offset.Partition)}
+	update := bson.M{
+		"$set": bson.M{
+			"group":      offset.Group,
+			"topic":      offset.Topic,
+			"partition":  offset.Partition,
+			"updated_at": time.Now().UTC(),
+		},
+		"$max": bson.M{"offset": offset.Offset},
+	}
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
 }
 
-// InsertTransactions inserts a batch of transactions into the database
-func (r *TxRepository) InsertTransactions(ctx context.Context, txs []interface{}) error {
-	collection := r.client.Database(r.database).Collection(r.collection)
-	_, err := collection.InsertMany(ctx, txs)
+// FetchOffsets returns the stored offsets of every partition of the topic for the given group
+func (r *TxRepository) FetchOffsets(ctx context.Context, group, topic string) (map[int32]int64, error) {
+	collection := r.client.Database(r.database).Collection(r.offsetsCollection)
+	cursor, err := collection.Find(ctx, bson.M{"group": group, "topic": topic})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+
+	var stored []models.PartitionOffset
+	if err = cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64, len(stored))
+	for _, o := range stored {
+		offsets[o.Partition] = o.Offset
+	}
+	return offsets, nil
+}
+
+// classifyWriteError marks the write errors which can never succeed when retried as permanent. With the
+// lifecycle a duplicate id means the stored status changed since it was read, which is retried.
+func (r *TxRepository) classifyWriteError(we mongo.WriteError) error {
+	switch {
+	case r.policy == Lifecycle && (we.HasErrorCode(11000) || we.HasErrorCode(11001)):
+		return errors.E(errors.Internal, "transaction status changed concurrently", we)
+	case we.HasErrorCode(11000), we.HasErrorCode(11001):
+		return errors.E(errors.Conflict, "duplicate transaction", we)
+	case we.HasErrorCode(121):
+		return errors.E(errors.Invalid, "transaction failed document validation", we)
+	default:
+		return errors.E(errors.Internal, "failed to write transaction", we)
+	}
+}
+
+func offsetID(group, topic string, partition int32) string {
+	return fmt.Sprintf("%s:%s:%d", group, topic, partition)
 }
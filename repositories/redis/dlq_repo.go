@@ -4,9 +4,12 @@ import (
 	// Go Internal Packages
 	"context"
 	"encoding/json"
-	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	// Local Packages
+	errors "tx-stream/errors"
 	models "tx-stream/models"
 
 	// External Packages
@@ -14,41 +17,270 @@ import (
 	"go.uber.org/zap"
 )
 
+// Fields of every dead letter entry in the stream
+const (
+	FieldKey       = "key"
+	FieldValue     = "value"
+	FieldTopic     = "topic"
+	FieldPartition = "partition"
+	FieldOffset    = "offset"
+	FieldTimestamp = "timestamp"
+	FieldHeaders   = "headers"
+	FieldReason    = "reason"
+	FieldDetails   = "details"
+	FieldAttempts  = "attempts"
+	FieldFailedAt  = "failed_at"
+)
+
+// DeadLetterQueue stores failed records in a Redis stream. Every failure is a separate stream entry,
+// so repeated failures of a key never overwrite each other, and triage workers claim entries through
+// a consumer group so each entry is handled by a single worker.
 type DeadLetterQueue struct {
 	client *redis.Client
+	stream string
+	group  string
+	maxLen int64
 	logger *zap.Logger
 }
 
-func NewDeadLetterQueue(client *redis.Client, logger *zap.Logger) *DeadLetterQueue {
-	return &DeadLetterQueue{client: client, logger: logger}
+// NewDeadLetterQueue creates the dead-letter queue backed by the given stream. The stream is capped at
+// approximately maxLen entries, unbounded when zero.
+func NewDeadLetterQueue(client *redis.Client, stream, group string, maxLen int64, logger *zap.Logger) *DeadLetterQueue {
+	return &DeadLetterQueue{client: client, stream: stream, group: group, maxLen: maxLen, logger: logger}
 }
 
-// Send stores all failed records into Redis with the key as "tx:{transaction_id}"
-func (r *DeadLetterQueue) Send(ctx context.Context, records []models.Record) error {
-	if len(records) == 0 {
+// EnsureGroup creates the stream and its triage consumer group if they do not exist yet
+func (r *DeadLetterQueue) EnsureGroup(ctx context.Context) error {
+	err := r.client.XGroupCreateMkStream(ctx, r.stream, r.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// Send appends all failed records along with the failure reason and source partition/offset to the stream
+func (r *DeadLetterQueue) Send(ctx context.Context, deadLetters []models.DeadLetter) error {
+	if len(deadLetters) == 0 {
 		return nil
 	}
 
-	successCount := 0
-	for _, record := range records {
-		jsonData, err := json.Marshal(record)
+	pipe := r.client.Pipeline()
+	for _, dl := range deadLetters {
+		// the record and the failure reason are always kept, only the fields which failed to encode are left out
+		values, err := r.encode(dl)
 		if err != nil {
-			r.logger.Error("failed to marshal record", zap.Error(err))
-			continue
+			r.logger.Error("failed to encode dead letter, sending it without headers or details",
+				zap.String("topic", dl.Record.Topic), zap.Int32("partition", dl.Record.Partition),
+				zap.Int64("offset", dl.Record.Offset), zap.Error(err))
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.stream,
+			MaxLen: r.maxLen,
+			Approx: true,
+			Values: values,
+		})
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	r.logger.Info("successfully sent records", zap.String("stream", r.stream), zap.Int("count", len(deadLetters)))
+	return nil
+}
+
+// Claim reads up to count entries not yet delivered to any worker of the group, blocking for up to
+// block when there are none. Claimed entries stay pending for the worker until acknowledged.
+func (r *DeadLetterQueue) Claim(ctx context.Context, worker string, count int64, block time.Duration) ([]models.DeadLetterEntry, error) {
+	streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    r.group,
+		Consumer: worker,
+		Streams:  []string{r.stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.DeadLetterEntry
+	for _, stream := range streams {
+		entries = append(entries, r.decodeAll(stream.Messages)...)
+	}
+	return entries, nil
+}
+
+// ClaimStale takes over up to count entries which have been pending with another worker for longer than
+// minIdle, so entries claimed by a crashed worker are not stuck forever.
+func (r *DeadLetterQueue) ClaimStale(ctx context.Context, worker string, minIdle time.Duration, count int64) ([]models.DeadLetterEntry, error) {
+	messages, _, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   r.stream,
+		Group:    r.group,
+		Consumer: worker,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeAll(messages), nil
+}
+
+// Ack acknowledges the handled entries for the group and removes them from the stream
+func (r *DeadLetterQueue) Ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.XAck(ctx, r.stream, r.group, ids...)
+	pipe.XDel(ctx, r.stream, ids...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// rangeMessages reads up to count entries following the entry with the given id, in the order they were added.
+// Unlike Claim it does not claim the entries, so entries which are left alone stay available to the group.
+func (r *DeadLetterQueue) rangeMessages(ctx context.Context, afterID string, count int64) ([]redis.XMessage, error) {
+	start := "-"
+	if afterID != "" {
+		start = "(" + afterID
+	}
+	return r.client.XRangeN(ctx, r.stream, start, "+", count).Result()
+}
+
+// Cursor returns a reader which walks the whole stream from the oldest entry, for replaying dead letters
+func (r *DeadLetterQueue) Cursor() *DeadLetterCursor {
+	return &DeadLetterCursor{dlq: r}
+}
+
+// DeadLetterCursor reads the stream in order, acknowledging an entry removes it from the stream.
+type DeadLetterCursor struct {
+	dlq    *DeadLetterQueue
+	lastID string
+}
+
+// Next returns the next batch of entries, an empty batch once the end of the stream is reached.
+// Entries which cannot be decoded are skipped.
+func (c *DeadLetterCursor) Next(ctx context.Context, count int) ([]models.DeadLetterEntry, error) {
+	for {
+		messages, err := c.dlq.rangeMessages(ctx, c.lastID, int64(count))
+		if err != nil || len(messages) == 0 {
+			return nil, err
+		}
+		c.lastID = messages[len(messages)-1].ID
+
+		if entries := c.dlq.decodeAll(messages); len(entries) > 0 {
+			return entries, nil
 		}
+	}
+}
+
+// Ack removes the entries from the stream
+func (c *DeadLetterCursor) Ack(ctx context.Context, entries []models.DeadLetterEntry) error {
+	ids := make([]string, len(entries))
+	for idx, entry := range entries {
+		ids[idx] = entry.ID
+	}
+	return c.dlq.Ack(ctx, ids...)
+}
+
+// encode returns the stream fields of the dead letter. Headers or details which cannot be encoded are left
+// out of the fields and reported in the error.
+func (r *DeadLetterQueue) encode(dl models.DeadLetter) (map[string]interface{}, error) {
+	values := map[string]interface{}{
+		FieldKey:       dl.Record.Key,
+		FieldValue:     dl.Record.Value,
+		FieldTopic:     dl.Record.Topic,
+		FieldPartition: dl.Record.Partition,
+		FieldOffset:    dl.Record.Offset,
+		FieldTimestamp: dl.Record.Timestamp.Format(time.RFC3339Nano),
+		FieldReason:    dl.Reason,
+		FieldAttempts:  dl.Attempts,
+		FieldFailedAt:  dl.FailedAt.Format(time.RFC3339Nano),
+	}
+	var err error
+	if len(dl.Record.Headers) > 0 {
+		headers, headersErr := json.Marshal(dl.Record.Headers)
+		if headersErr != nil {
+			err = errors.E(errors.Invalid, "failed to encode dead letter headers", headersErr)
+		} else {
+			values[FieldHeaders] = headers
+		}
+	}
+	if len(dl.Details) > 0 {
+		details, detailsErr := json.Marshal(dl.Details)
+		if detailsErr != nil {
+			err = errors.E(errors.Invalid, "failed to encode dead letter details", detailsErr)
+		} else {
+			values[FieldDetails] = details
+		}
+	}
+	return values, err
+}
 
-		key := fmt.Sprintf("failed-tx:%s", record.Key)
-		err = r.client.Set(ctx, key, jsonData, 0).Err()
+func (r *DeadLetterQueue) decodeAll(messages []redis.XMessage) []models.DeadLetterEntry {
+	entries := make([]models.DeadLetterEntry, 0, len(messages))
+	for _, message := range messages {
+		entry, err := decode(message)
 		if err != nil {
-			r.logger.Error("failed to store record", zap.String("key", key), zap.Error(err))
+			r.logger.Error("failed to decode dead letter", zap.String("id", message.ID), zap.Error(err))
 			continue
 		}
-		successCount++
+		entries = append(entries, entry)
 	}
+	return entries
+}
 
-	if successCount > 0 {
-		r.logger.Info("successfully sent records", zap.Int("count", successCount))
+func decode(message redis.XMessage) (models.DeadLetterEntry, error) {
+	field := func(name string) string {
+		value, _ := message.Values[name].(string)
+		return value
 	}
 
-	return nil
+	dl := models.DeadLetter{
+		Record: models.Record{
+			Key:   []byte(field(FieldKey)),
+			Value: []byte(field(FieldValue)),
+			Topic: field(FieldTopic),
+		},
+		Reason: field(FieldReason),
+	}
+
+	partition, err := strconv.ParseInt(field(FieldPartition), 10, 32)
+	if err != nil {
+		return models.DeadLetterEntry{}, err
+	}
+	dl.Record.Partition = int32(partition)
+
+	if dl.Record.Offset, err = strconv.ParseInt(field(FieldOffset), 10, 64); err != nil {
+		return models.DeadLetterEntry{}, err
+	}
+	if timestamp := field(FieldTimestamp); timestamp != "" {
+		if dl.Record.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return models.DeadLetterEntry{}, err
+		}
+	}
+	if headers := field(FieldHeaders); headers != "" {
+		if err = json.Unmarshal([]byte(headers), &dl.Record.Headers); err != nil {
+			return models.DeadLetterEntry{}, err
+		}
+	}
+	if dl.Attempts, err = strconv.Atoi(field(FieldAttempts)); err != nil {
+		return models.DeadLetterEntry{}, err
+	}
+	if dl.FailedAt, err = time.Parse(time.RFC3339Nano, field(FieldFailedAt)); err != nil {
+		return models.DeadLetterEntry{}, err
+	}
+	if details := field(FieldDetails); details != "" {
+		if err = json.Unmarshal([]byte(details), &dl.Details); err != nil {
+			return models.DeadLetterEntry{}, err
+		}
+	}
+
+	return models.DeadLetterEntry{ID: message.ID, DeadLetter: dl}, nil
 }
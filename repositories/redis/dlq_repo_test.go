@@ -0,0 +1,171 @@
+package redis
+
+import (
+	// Go Internal Packages
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newTestQueue returns a dead-letter queue on an in-memory redis along with the server
+func newTestQueue(t *testing.T) (*DeadLetterQueue, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	dlq := NewDeadLetterQueue(client, "dlq", "triage", 0, zap.NewNop())
+	if err := dlq.EnsureGroup(context.Background()); err != nil {
+		t.Fatalf("EnsureGroup() error = %v", err)
+	}
+	return dlq, server
+}
+
+// deadLetters returns count dead letters of consecutive offsets
+func deadLetters(count int) []models.DeadLetter {
+	failedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	dls := make([]models.DeadLetter, count)
+	for idx := range dls {
+		dls[idx] = models.DeadLetter{
+			Record: models.Record{
+				Key:       []byte(fmt.Sprintf("tx-%d", idx)),
+				Value:     []byte("{}"),
+				Topic:     "transactions",
+				Offset:    int64(idx),
+				Timestamp: failedAt,
+				Headers:   []models.RecordHeader{{Key: "trace_id", Value: []byte("trace")}},
+			},
+			Reason:   "invalid transaction",
+			Details:  errors.ValidationErrors{{Field: "amount", Error: "is required"}},
+			Attempts: 1,
+			FailedAt: failedAt,
+		}
+	}
+	return dls
+}
+
+// ids returns the ids of the entries
+func ids(entries []models.DeadLetterEntry) []string {
+	ids := make([]string, len(entries))
+	for idx, entry := range entries {
+		ids[idx] = entry.ID
+	}
+	return ids
+}
+
+func TestDeadLetterQueueClaimAndAck(t *testing.T) {
+	dlq, server := newTestQueue(t)
+	ctx := context.Background()
+	if err := dlq.Send(ctx, deadLetters(3)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	first, err := dlq.Claim(ctx, "worker-1", 2, 0)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if len(first) != 2 {
+		t.Fatalf("Claim() = %d entries, want 2", len(first))
+	}
+	if dl := first[0].DeadLetter; string(dl.Record.Key) != "tx-0" || len(dl.Record.Headers) != 1 || len(dl.Details) != 1 {
+		t.Errorf("Claim() first entry = %+v, want tx-0 with its headers and details", dl)
+	}
+
+	// claimed entries are not delivered to another worker
+	second, err := dlq.Claim(ctx, "worker-2", 10, 0)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	if len(second) != 1 || string(second[0].DeadLetter.Record.Key) != "tx-2" {
+		t.Fatalf("Claim() by another worker = %v, want only tx-2", ids(second))
+	}
+
+	if err = dlq.Ack(ctx, ids(first)...); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	entries, err := server.Stream("dlq")
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != second[0].ID {
+		t.Errorf("stream holds %d entries after the ack, want only %s", len(entries), second[0].ID)
+	}
+}
+
+func TestDeadLetterQueueClaimStale(t *testing.T) {
+	dlq, _ := newTestQueue(t)
+	ctx := context.Background()
+	if err := dlq.Send(ctx, deadLetters(2)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	claimed, err := dlq.Claim(ctx, "crashed", 10, 0)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("Claim() = %d entries, error = %v, want 2", len(claimed), err)
+	}
+
+	// entries pending for less than the minimum idle time stay with their worker
+	stale, err := dlq.ClaimStale(ctx, "worker", time.Hour, 10)
+	if err != nil {
+		t.Fatalf("ClaimStale() error = %v", err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("ClaimStale() = %v, want nothing idle for an hour", ids(stale))
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	stale, err = dlq.ClaimStale(ctx, "worker", 10*time.Millisecond, 10)
+	if err != nil {
+		t.Fatalf("ClaimStale() error = %v", err)
+	}
+	if len(stale) != 2 || stale[0].ID != claimed[0].ID || stale[1].ID != claimed[1].ID {
+		t.Fatalf("ClaimStale() = %v, want the entries of the crashed worker %v", ids(stale), ids(claimed))
+	}
+
+	// the reclaimed entries are removed once the new worker acknowledges them
+	if err = dlq.Ack(ctx, ids(stale)...); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	if stale, err = dlq.ClaimStale(ctx, "worker", 0, 10); err != nil || len(stale) != 0 {
+		t.Errorf("ClaimStale() after the ack = %v, error = %v, want nothing pending", ids(stale), err)
+	}
+}
+
+func TestDeadLetterCursor(t *testing.T) {
+	dlq, _ := newTestQueue(t)
+	ctx := context.Background()
+	if err := dlq.Send(ctx, deadLetters(3)); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	cursor := dlq.Cursor()
+	var read []models.DeadLetterEntry
+	for {
+		entries, err := cursor.Next(ctx, 2)
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		read = append(read, entries...)
+	}
+	if len(read) != 3 {
+		t.Fatalf("cursor read %d entries, want 3", len(read))
+	}
+	for idx, entry := range read {
+		if entry.DeadLetter.Record.Offset != int64(idx) {
+			t.Errorf("entry %d has offset %d, want %d", idx, entry.DeadLetter.Record.Offset, idx)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	// Go Internal Packages
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	// External Packages
+	"go.uber.org/zap"
+)
+
+// Server is the embedded HTTP server exposing the operational endpoints
+type Server struct {
+	mux    *http.ServeMux
+	server *http.Server
+	logger *zap.Logger
+}
+
+func NewServer(address string, logger *zap.Logger) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+// Handle registers the handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start starts serving in a separate goroutine
+func (s *Server) Start() {
+	s.logger.Info("starting http server", zap.String("address", s.server.Addr))
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("http server stopped", zap.Error(err))
+		}
+	}()
+}
+
+// Shutdown stops accepting connections and waits for the in-flight requests to complete
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
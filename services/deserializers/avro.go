@@ -0,0 +1,116 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+
+	// Local Packages
+	errors "tx-stream/errors"
+
+	// External Packages
+	"github.com/hamba/avro/v2"
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+// AvroDeserializer decodes Avro values in the Confluent wire format with the writer schema from the registry
+type AvroDeserializer struct {
+	registry *SchemaRegistry
+	header   sr.ConfluentHeader
+
+	mu      sync.RWMutex
+	schemas map[int]avro.Schema
+}
+
+// NewAvroDeserializer creates an Avro deserializer resolving schemas from the registry
+func NewAvroDeserializer(registry *SchemaRegistry) *AvroDeserializer {
+	return &AvroDeserializer{registry: registry, schemas: make(map[int]avro.Schema)}
+}
+
+// Deserialize decodes the value and returns it as a JSON document
+func (d *AvroDeserializer) Deserialize(ctx context.Context, value []byte) ([]byte, error) {
+	id, payload, err := d.header.DecodeID(value)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, "invalid avro wire format", err)
+	}
+
+	schema, err := d.schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err = avro.Unmarshal(schema, payload, &doc); err != nil {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("failed to decode avro with schema %d", id), err)
+	}
+	return json.Marshal(jsonValue(doc))
+}
+
+// schema returns the parsed schema with the given id
+func (d *AvroDeserializer) schema(ctx context.Context, id int) (avro.Schema, error) {
+	d.mu.RLock()
+	schema, ok := d.schemas[id]
+	d.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	registered, err := d.registry.Schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if registered.Type != sr.TypeAvro {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("schema %d is %s, not avro", id, registered.Type))
+	}
+	if schema, err = avro.Parse(registered.Schema); err != nil {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("failed to parse avro schema %d", id), err)
+	}
+
+	d.mu.Lock()
+	d.schemas[id] = schema
+	d.mu.Unlock()
+	return schema, nil
+}
+
+// jsonValue converts decoded avro values which have no faithful JSON encoding, decimals are
+// written as exact JSON numbers instead of fractions
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case []any:
+		for idx, value := range v {
+			v[idx] = jsonValue(value)
+		}
+		return v
+	case *big.Rat:
+		return json.Number(exactDecimal(v))
+	default:
+		return v
+	}
+}
+
+// exactDecimal formats the rational with as many decimal places as it needs. Avro decimals are scaled
+// integers, so once reduced their denominator is 2^a * 5^b and max(a, b) places represent them exactly.
+func exactDecimal(r *big.Rat) string {
+	return r.FloatString(max(factors(r.Denom(), 2), factors(r.Denom(), 5)))
+}
+
+// factors returns how many times the prime divides n
+func factors(n *big.Int, prime int64) int {
+	p := big.NewInt(prime)
+	q, rem := new(big.Int), new(big.Int)
+	n = new(big.Int).Set(n)
+	for count := 0; ; count++ {
+		if q.QuoRem(n, p, rem); rem.Sign() != 0 {
+			return count
+		}
+		n.Set(q)
+	}
+}
@@ -0,0 +1,65 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"context"
+	"fmt"
+	"strings"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+)
+
+// FormatOf returns the format of a content type like "application/avro" or "application/x-protobuf"
+func FormatOf(contentType string) (models.ValueFormat, bool) {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "avro"):
+		return models.FormatAvro, true
+	case strings.Contains(contentType, "protobuf"):
+		return models.FormatProtobuf, true
+	case strings.Contains(contentType, "json"):
+		return models.FormatJSON, true
+	default:
+		return "", false
+	}
+}
+
+// Deserializer converts a record value into the JSON document of a transaction. Values which cannot be
+// decoded fail with an errors.Invalid error, failures to resolve their schema are retryable.
+type Deserializer interface {
+	Deserialize(ctx context.Context, value []byte) ([]byte, error)
+}
+
+// Selector deserializes each record with the deserializer of its content-type header,
+// falling back to the default format for records without one.
+type Selector struct {
+	defaultFormat models.ValueFormat
+	deserializers map[models.ValueFormat]Deserializer
+}
+
+// NewSelector creates a selector over the deserializers keyed by format, failing unless the default
+// format is one of them
+func NewSelector(defaultFormat models.ValueFormat, deserializers map[models.ValueFormat]Deserializer) (*Selector, error) {
+	if _, ok := deserializers[defaultFormat]; !ok {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("no deserializer for the default format %q", defaultFormat))
+	}
+	return &Selector{defaultFormat: defaultFormat, deserializers: deserializers}, nil
+}
+
+// Deserialize converts the record value into the JSON document of a transaction
+func (s *Selector) Deserialize(ctx context.Context, record models.Record) ([]byte, error) {
+	format := s.defaultFormat
+	if contentType, ok := record.Header(models.HeaderContentType); ok {
+		if format, ok = FormatOf(contentType); !ok {
+			return nil, errors.E(errors.Invalid, fmt.Sprintf("unsupported content type %q", contentType))
+		}
+	}
+
+	deserializer, ok := s.deserializers[format]
+	if !ok {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("no deserializer configured for %s", format))
+	}
+	return deserializer.Deserialize(ctx, record.Value)
+}
@@ -0,0 +1,339 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/bufbuild/protocompile"
+	"github.com/hamba/avro/v2"
+	"github.com/twmb/franz-go/pkg/sr"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const avroSchema = `{
+  "type": "record",
+  "name": "Transaction",
+  "fields": [
+    {"name": "transaction_id", "type": "string"},
+    {"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 12, "scale": 2}},
+    {"name": "currency", "type": "string"}
+  ]
+}`
+
+const protoSchema = `syntax = "proto3";
+package payments;
+
+message Envelope {
+  string source = 1;
+}
+
+message Transaction {
+  string transaction_id = 1;
+  double amount = 2;
+  string currency = 3;
+}`
+
+const protoInt64Schema = `syntax = "proto3";
+package payments;
+
+import "google/protobuf/wrappers.proto";
+
+message Fee {
+  uint64 minor_units = 1;
+}
+
+message Transaction {
+  string transaction_id = 1;
+  int64 amount = 2;
+  repeated sint64 refunds = 3;
+  Fee fee = 4;
+  map<string, fixed64> limits = 5;
+  google.protobuf.Int64Value points = 6;
+}`
+
+// fakeRegistry serves schemas by id like a schema registry, counting the lookups of each id
+type fakeRegistry struct {
+	mu      sync.Mutex
+	schemas map[int]sr.Schema
+	lookups map[int]int
+}
+
+func newFakeRegistry(t *testing.T, schemas map[int]sr.Schema) (*fakeRegistry, *SchemaRegistry) {
+	t.Helper()
+	fake := &fakeRegistry{schemas: schemas, lookups: make(map[int]int)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	registry, err := NewSchemaRegistry(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("cannot create registry client: %v", err)
+	}
+	return fake, registry
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/schemas/ids/%d", &id); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	f.lookups[id]++
+	schema, ok := f.schemas[id]
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error_code": 40403, "message": "Schema not found"}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(schema)
+}
+
+func (f *fakeRegistry) lookupsOf(id int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lookups[id]
+}
+
+// wireFormat prepends the Confluent header to the payload
+func wireFormat(id int, index []int, payload []byte) []byte {
+	value, _ := (&sr.ConfluentHeader{}).AppendEncode(nil, id, index)
+	return append(value, payload...)
+}
+
+func TestAvroDeserializer(t *testing.T) {
+	fake, registry := newFakeRegistry(t, map[int]sr.Schema{1: {Schema: avroSchema, Type: sr.TypeAvro}})
+	d := NewAvroDeserializer(registry)
+
+	// the decimals are reduced fractions, 12.75 is 51/4 and 0.05 is 1/20
+	for _, amount := range []string{"1499.99", "12.75", "0.05", "0.25", "0.5", "100"} {
+		rat, _ := new(big.Rat).SetString(amount)
+		payload, err := avro.Marshal(avro.MustParse(avroSchema), map[string]any{
+			"transaction_id": "tx-1",
+			"amount":         rat,
+			"currency":       "INR",
+		})
+		if err != nil {
+			t.Fatalf("cannot encode avro: %v", err)
+		}
+
+		doc, err := d.Deserialize(context.Background(), wireFormat(1, nil, payload))
+		if err != nil {
+			t.Fatalf("Deserialize() error = %v", err)
+		}
+
+		var tx models.Transaction
+		if err = json.Unmarshal(doc, &tx); err != nil {
+			t.Fatalf("cannot decode %s: %v", doc, err)
+		}
+		if tx.TxID != "tx-1" || tx.Amount.String() != amount || tx.Currency != "INR" {
+			t.Errorf("decoded %s, want tx-1 for %s INR", doc, amount)
+		}
+	}
+
+	if lookups := fake.lookupsOf(1); lookups != 1 {
+		t.Errorf("schema looked up %d times, want 1 as it is cached", lookups)
+	}
+}
+
+func TestProtobufDeserializer(t *testing.T) {
+	_, registry := newFakeRegistry(t, map[int]sr.Schema{2: {Schema: protoSchema, Type: sr.TypeProtobuf}})
+	d := NewProtobufDeserializer(registry)
+
+	compiler := protocompile.Compiler{Resolver: &protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{"tx.proto": protoSchema}),
+	}}
+	files, err := compiler.Compile(context.Background(), "tx.proto")
+	if err != nil {
+		t.Fatalf("cannot compile schema: %v", err)
+	}
+
+	descriptor := files[0].Messages().ByName("Transaction")
+	msg := dynamicpb.NewMessage(descriptor)
+	msg.Set(descriptor.Fields().ByName("transaction_id"), protoValue("tx-2"))
+	msg.Set(descriptor.Fields().ByName("amount"), protoValue(250.5))
+	msg.Set(descriptor.Fields().ByName("currency"), protoValue("USD"))
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("cannot encode protobuf: %v", err)
+	}
+
+	// Transaction is the second message of the file
+	doc, err := d.Deserialize(context.Background(), wireFormat(2, []int{1}, payload))
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+
+	var tx models.Transaction
+	if err = json.Unmarshal(doc, &tx); err != nil {
+		t.Fatalf("cannot decode %s: %v", doc, err)
+	}
+	if tx.TxID != "tx-2" || tx.Amount.String() != "250.5" || tx.Currency != "USD" {
+		t.Errorf("decoded %s, want tx-2 for 250.5 USD", doc)
+	}
+}
+
+// the 64-bit integers are decoded as JSON numbers, not as the strings protojson renders them as
+func TestProtobufDeserializerInt64(t *testing.T) {
+	_, registry := newFakeRegistry(t, map[int]sr.Schema{3: {Schema: protoInt64Schema, Type: sr.TypeProtobuf}})
+	d := NewProtobufDeserializer(registry)
+
+	compiler := protocompile.Compiler{Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+		Accessor: protocompile.SourceAccessorFromMap(map[string]string{"tx.proto": protoInt64Schema}),
+	})}
+	files, err := compiler.Compile(context.Background(), "tx.proto")
+	if err != nil {
+		t.Fatalf("cannot compile schema: %v", err)
+	}
+
+	descriptor := files[0].Messages().ByName("Transaction")
+	fields := descriptor.Fields()
+	msg := dynamicpb.NewMessage(descriptor)
+	msg.Set(fields.ByName("transaction_id"), protoValue("tx-3"))
+	msg.Set(fields.ByName("amount"), protoValue(int64(149999)))
+	refunds := msg.Mutable(fields.ByName("refunds")).List()
+	refunds.Append(protoValue(int64(-100)))
+	fee := msg.Mutable(fields.ByName("fee")).Message()
+	fee.Set(fee.Descriptor().Fields().ByName("minor_units"), protoValue(uint64(250)))
+	msg.Mutable(fields.ByName("limits")).Map().Set(protoValue("daily").MapKey(), protoValue(uint64(5000)))
+	points := msg.Mutable(fields.ByName("points")).Message()
+	points.Set(points.Descriptor().Fields().ByName("value"), protoValue(int64(7)))
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("cannot encode protobuf: %v", err)
+	}
+
+	doc, err := d.Deserialize(context.Background(), wireFormat(3, []int{1}, payload))
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	for _, want := range []string{`"amount":149999`, `"refunds":[-100]`, `"fee":{"minor_units":250}`, `"limits":{"daily":5000}`, `"points":7`} {
+		if !strings.Contains(string(doc), want) {
+			t.Errorf("decoded %s, want it to contain %s", doc, want)
+		}
+	}
+
+	var tx models.Transaction
+	if err = json.Unmarshal(doc, &tx); err != nil {
+		t.Fatalf("cannot decode %s: %v", doc, err)
+	}
+	if tx.Amount.String() != "149999" {
+		t.Errorf("decoded amount %s, want 149999", tx.Amount)
+	}
+}
+
+func TestDeserializerErrors(t *testing.T) {
+	_, registry := newFakeRegistry(t, map[int]sr.Schema{1: {Schema: avroSchema, Type: sr.TypeAvro}})
+	unreachable, err := NewSchemaRegistry("http://127.0.0.1:1", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("cannot create registry client: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		d         Deserializer
+		value     []byte
+		permanent bool
+	}{
+		{"missing header", NewAvroDeserializer(registry), []byte("{}"), true},
+		{"unknown schema", NewAvroDeserializer(registry), wireFormat(99, nil, []byte{0}), true},
+		{"wrong schema type", NewProtobufDeserializer(registry), wireFormat(1, []int{0}, []byte{0}), true},
+		{"corrupt payload", NewAvroDeserializer(registry), wireFormat(1, nil, []byte{0x14}), true},
+		{"registry unreachable", NewAvroDeserializer(unreachable), wireFormat(1, nil, []byte{0}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.d.Deserialize(context.Background(), tt.value)
+			if err == nil {
+				t.Fatal("Deserialize() succeeded, want an error")
+			}
+			if permanent := errors.IsPermanent(err); permanent != tt.permanent {
+				t.Errorf("IsPermanent() = %v, want %v for %v", permanent, tt.permanent, err)
+			}
+		})
+	}
+}
+
+func TestSelector(t *testing.T) {
+	s, err := NewSelector(models.FormatJSON, map[models.ValueFormat]Deserializer{
+		models.FormatJSON: JSONDeserializer{},
+		models.FormatAvro: deserializerFunc(func([]byte) []byte { return []byte(`{"from":"avro"}`) }),
+	})
+	if err != nil {
+		t.Fatalf("NewSelector() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		value       string
+		want        string
+		wantErr     bool
+	}{
+		{"default format", "", `{"from":"json"}`, `{"from":"json"}`, false},
+		{"content type", "application/avro", "binary", `{"from":"avro"}`, false},
+		{"confluent json", "application/json", "\x00\x00\x00\x00\x01{}", `{}`, false},
+		{"unknown content type", "text/plain", "", "", true},
+		{"unconfigured format", "application/x-protobuf", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := models.Record{Value: []byte(tt.value)}
+			if tt.contentType != "" {
+				record.Headers = []models.RecordHeader{{Key: models.HeaderContentType, Value: []byte(tt.contentType)}}
+			}
+
+			got, err := s.Deserialize(context.Background(), record)
+			if tt.wantErr {
+				if !errors.IsPermanent(err) {
+					t.Errorf("Deserialize() error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil || strings.TrimSpace(string(got)) != tt.want {
+				t.Errorf("Deserialize() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorRequiresDefaultFormat(t *testing.T) {
+	// avro is only available along with a schema registry
+	_, err := NewSelector(models.FormatAvro, map[models.ValueFormat]Deserializer{models.FormatJSON: JSONDeserializer{}})
+	if !errors.IsPermanent(err) {
+		t.Errorf("NewSelector() error = %v, want a permanent error", err)
+	}
+}
+
+// deserializerFunc adapts a function into a Deserializer
+type deserializerFunc func(value []byte) []byte
+
+func (f deserializerFunc) Deserialize(_ context.Context, value []byte) ([]byte, error) {
+	return f(value), nil
+}
+
+// protoValue wraps a scalar into a protoreflect value
+func protoValue(v any) protoreflect.Value {
+	return protoreflect.ValueOf(v)
+}
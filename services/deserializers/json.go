@@ -0,0 +1,18 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"context"
+)
+
+// JSONDeserializer passes JSON values through, stripping the Confluent wire format header when present
+type JSONDeserializer struct{}
+
+// Deserialize returns the JSON document of the value
+func (JSONDeserializer) Deserialize(_ context.Context, value []byte) ([]byte, error) {
+	// JSON text never starts with the zero magic byte, so its presence marks the 5 byte header
+	if len(value) >= 5 && value[0] == 0 {
+		return value[5:], nil
+	}
+	return value, nil
+}
@@ -0,0 +1,197 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	// Local Packages
+	errors "tx-stream/errors"
+
+	// External Packages
+	"github.com/bufbuild/protocompile"
+	"github.com/twmb/franz-go/pkg/sr"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// schemaFile is the name the registered schema is compiled under
+const schemaFile = "schema.proto"
+
+// ProtobufDeserializer decodes Protobuf values in the Confluent wire format, resolving the message
+// from the writer schema in the registry and the message indexes in the header.
+type ProtobufDeserializer struct {
+	registry *SchemaRegistry
+	header   sr.ConfluentHeader
+	marshal  protojson.MarshalOptions
+
+	mu    sync.RWMutex
+	files map[int]protoreflect.FileDescriptor
+}
+
+// NewProtobufDeserializer creates a Protobuf deserializer resolving schemas from the registry
+func NewProtobufDeserializer(registry *SchemaRegistry) *ProtobufDeserializer {
+	return &ProtobufDeserializer{
+		registry: registry,
+		marshal:  protojson.MarshalOptions{UseProtoNames: true},
+		files:    make(map[int]protoreflect.FileDescriptor),
+	}
+}
+
+// Deserialize decodes the value and returns it as a JSON document with the proto field names, and the 64-bit
+// integers as JSON numbers
+func (d *ProtobufDeserializer) Deserialize(ctx context.Context, value []byte) ([]byte, error) {
+	id, payload, err := d.header.DecodeID(value)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, "invalid protobuf wire format", err)
+	}
+	index, payload, err := d.header.DecodeIndex(payload, 0)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, "invalid protobuf message indexes", err)
+	}
+
+	file, err := d.file(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := messageAt(file, index)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("schema %d", id), err)
+	}
+
+	msg := dynamicpb.NewMessage(descriptor)
+	if err = proto.Unmarshal(payload, msg); err != nil {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("failed to decode %s", descriptor.FullName()), err)
+	}
+	data, err := d.marshal.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// protojson renders the 64-bit integers as strings, which would not decode like the integers of other formats
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc map[string]any
+	if err = decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	restoreNumbers(descriptor, doc)
+	return json.Marshal(doc)
+}
+
+// restoreNumbers replaces the 64-bit integers of the message rendered as strings by protojson with JSON numbers
+func restoreNumbers(descriptor protoreflect.MessageDescriptor, doc map[string]any) {
+	fields := descriptor.Fields()
+	for idx := 0; idx < fields.Len(); idx++ {
+		field := fields.Get(idx)
+		name := string(field.Name())
+		value, ok := doc[name]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case field.IsMap():
+			entries, _ := value.(map[string]any)
+			for key, entry := range entries {
+				entries[key] = numberValue(field.MapValue(), entry)
+			}
+		case field.IsList():
+			items, _ := value.([]any)
+			for i, item := range items {
+				items[i] = numberValue(field, item)
+			}
+		default:
+			doc[name] = numberValue(field, value)
+		}
+	}
+}
+
+// numberValue returns a single value of the field with its 64-bit integers as JSON numbers. Well-known types
+// other than the 64-bit wrappers have their own JSON representation and are left as they are.
+func numberValue(field protoreflect.FieldDescriptor, value any) any {
+	switch field.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		message := field.Message()
+		switch {
+		case message.FullName() == "google.protobuf.Int64Value", message.FullName() == "google.protobuf.UInt64Value":
+		case message.ParentFile().Package() == "google.protobuf":
+			return value
+		default:
+			if nested, ok := value.(map[string]any); ok {
+				restoreNumbers(message, nested)
+			}
+			return value
+		}
+	default:
+		return value
+	}
+
+	if s, ok := value.(string); ok {
+		return json.Number(s)
+	}
+	return value
+}
+
+// file returns the compiled schema with the given id
+func (d *ProtobufDeserializer) file(ctx context.Context, id int) (protoreflect.FileDescriptor, error) {
+	d.mu.RLock()
+	file, ok := d.files[id]
+	d.mu.RUnlock()
+	if ok {
+		return file, nil
+	}
+
+	registered, err := d.registry.Schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if registered.Type != sr.TypeProtobuf {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("schema %d is %s, not protobuf", id, registered.Type))
+	}
+	sources, err := d.registry.References(ctx, registered)
+	if err != nil {
+		return nil, err
+	}
+	sources[schemaFile] = registered.Schema
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(sources),
+		}),
+	}
+	files, err := compiler.Compile(ctx, schemaFile)
+	if err != nil {
+		return nil, errors.E(errors.Invalid, fmt.Sprintf("failed to compile protobuf schema %d", id), err)
+	}
+
+	d.mu.Lock()
+	d.files[id] = files[0]
+	d.mu.Unlock()
+	return files[0], nil
+}
+
+// messageAt resolves the message indexes of the wire format, the first index selects a top level
+// message of the file and every following index a message nested in the previous one
+func messageAt(file protoreflect.FileDescriptor, index []int) (protoreflect.MessageDescriptor, error) {
+	messages := file.Messages()
+	var descriptor protoreflect.MessageDescriptor
+	for _, idx := range index {
+		if idx < 0 || idx >= messages.Len() {
+			return nil, fmt.Errorf("message index %v out of range", index)
+		}
+		descriptor = messages.Get(idx)
+		messages = descriptor.Messages()
+	}
+	if descriptor == nil {
+		return nil, errors.NewError("no message index")
+	}
+	return descriptor, nil
+}
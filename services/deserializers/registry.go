@@ -0,0 +1,80 @@
+package deserializers
+
+import (
+	// Go Internal Packages
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	// Local Packages
+	errors "tx-stream/errors"
+
+	// External Packages
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+// schemaNotFound is the schema registry error code of an unknown schema id
+const schemaNotFound = 40403
+
+// SchemaRegistry resolves schemas by id from a Confluent compatible schema registry, caching them locally
+// as schemas are immutable once registered.
+type SchemaRegistry struct {
+	client *sr.Client
+
+	mu      sync.RWMutex
+	schemas map[int]sr.Schema
+}
+
+// NewSchemaRegistry creates a registry client for the schema registry at url
+func NewSchemaRegistry(url string, timeout time.Duration) (*SchemaRegistry, error) {
+	client, err := sr.NewClient(sr.URLs(url), sr.HTTPClient(&http.Client{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaRegistry{client: client, schemas: make(map[int]sr.Schema)}, nil
+}
+
+// Schema returns the schema with the given id. Unknown ids fail with an errors.Invalid error,
+// any other failure to reach the registry is retryable.
+func (r *SchemaRegistry) Schema(ctx context.Context, id int) (sr.Schema, error) {
+	r.mu.RLock()
+	schema, ok := r.schemas[id]
+	r.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := r.client.SchemaByID(ctx, id)
+	if err != nil {
+		return sr.Schema{}, registryError(fmt.Sprintf("failed to fetch schema %d", id), err)
+	}
+
+	r.mu.Lock()
+	r.schemas[id] = schema
+	r.mu.Unlock()
+	return schema, nil
+}
+
+// References returns the text of the schemas referenced by the schema, keyed by reference name
+func (r *SchemaRegistry) References(ctx context.Context, schema sr.Schema) (map[string]string, error) {
+	refs := make(map[string]string, len(schema.References))
+	for _, ref := range schema.References {
+		referenced, err := r.client.SchemaByVersion(ctx, ref.Subject, ref.Version)
+		if err != nil {
+			return nil, registryError(fmt.Sprintf("failed to fetch reference %s", ref.Name), err)
+		}
+		refs[ref.Name] = referenced.Schema.Schema
+	}
+	return refs, nil
+}
+
+// registryError classifies a schema registry failure, only unknown schemas are permanent
+func registryError(message string, err error) error {
+	var respErr *sr.ResponseError
+	if errors.As(err, &respErr) && respErr.ErrorCode == schemaNotFound {
+		return errors.E(errors.Invalid, message, err)
+	}
+	return errors.E(errors.Internal, message, err)
+}
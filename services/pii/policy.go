@@ -0,0 +1,215 @@
+package pii
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+)
+
+// Action is how a PII field is sanitized
+type Action string
+
+const (
+	Mask     Action = "mask"     // Replace all but the last four characters with '*'
+	Hash     Action = "hash"     // Replace with the hex HMAC-SHA256 of the value
+	Tokenize Action = "tokenize" // Replace with a keyed token of the same shape, keeping the last four characters
+	Drop     Action = "drop"     // Remove the field
+)
+
+// IsValid reports whether the action is known
+func (a Action) IsValid() bool {
+	switch a {
+	case Mask, Hash, Tokenize, Drop:
+		return true
+	default:
+		return false
+	}
+}
+
+// NeedsKey reports whether the action requires the HMAC key
+func (a Action) NeedsKey() bool {
+	return a == Hash || a == Tokenize
+}
+
+// visibleChars is how many trailing characters mask and tokenize keep
+const visibleChars = 4
+
+// panRegex matches runs of 13 to 19 digits, optionally separated by spaces or dashes, which look like card numbers
+var panRegex = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+// Policy sanitizes the PII fields of a transaction document, each field with its configured action
+type Policy struct {
+	key    []byte
+	fields map[string]Action
+}
+
+// NewPolicy creates a policy for the fields, keyed by dotted JSON path like "card_number" or "payer.ip_address".
+// The key is required by the hash and tokenize actions.
+func NewPolicy(key []byte, fields map[string]string) (*Policy, error) {
+	p := &Policy{key: key, fields: make(map[string]Action, len(fields))}
+	for field, action := range fields {
+		a := Action(action)
+		if !a.IsValid() {
+			return nil, fmt.Errorf("unknown pii action %q for %s", action, field)
+		}
+		if a.NeedsKey() && len(key) == 0 {
+			return nil, fmt.Errorf("pii action %s for %s requires a key", action, field)
+		}
+		p.fields[field] = a
+	}
+	return p, nil
+}
+
+// Sanitize applies the policy to the JSON document, fails with an errors.Invalid error if it is not a JSON object
+func (p *Policy) Sanitize(doc []byte) ([]byte, error) {
+	var fields map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, errors.E(errors.Invalid, "cannot sanitize document", err)
+	}
+
+	for path, action := range p.fields {
+		p.apply(fields, strings.Split(path, "."), action)
+	}
+	return json.Marshal(fields)
+}
+
+// apply sanitizes the field at path within the object, ignoring paths which do not exist
+func (p *Policy) apply(fields map[string]any, path []string, action Action) {
+	value, ok := fields[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) > 1 {
+		if nested, ok := value.(map[string]any); ok {
+			p.apply(nested, path[1:], action)
+		}
+		return
+	}
+
+	if action == Drop || value == nil {
+		delete(fields, path[0])
+		return
+	}
+	fields[path[0]] = p.sanitizeValue(fmt.Sprint(value), action)
+}
+
+// sanitizeValue applies the action to a single value
+func (p *Policy) sanitizeValue(value string, action Action) string {
+	switch action {
+	case Hash:
+		mac := hmac.New(sha256.New, p.key)
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	case Tokenize:
+		return p.tokenize(value)
+	default:
+		return mask(value)
+	}
+}
+
+// Scrub masks everything that looks like a card number in a value which could not be sanitized field by field.
+// Masking keeps the length, so binary encodings stay decodable.
+func (p *Policy) Scrub(value []byte) []byte {
+	return panRegex.ReplaceAllFunc(value, func(pan []byte) []byte {
+		return []byte(mask(string(pan)))
+	})
+}
+
+// mask replaces every letter and digit but the last four with '*'
+func mask(value string) string {
+	runes := []rune(value)
+	for idx := 0; idx < len(runes)-visibleChars; idx++ {
+		if unicode.IsLetter(runes[idx]) || unicode.IsDigit(runes[idx]) {
+			runes[idx] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// tokenize replaces every letter and digit but the last four with one derived from the keyed HMAC of the
+// value, so the token keeps the shape of the value and the same value always maps to the same token
+func (p *Policy) tokenize(value string) string {
+	runes := []rune(value)
+	stream := p.keyStream([]byte(value), len(runes))
+	for idx := 0; idx < len(runes)-visibleChars; idx++ {
+		switch r := runes[idx]; {
+		case unicode.IsDigit(r):
+			runes[idx] = '0' + rune(stream[idx]%10)
+		case unicode.IsUpper(r):
+			runes[idx] = 'A' + rune(stream[idx]%26)
+		case unicode.IsLetter(r):
+			runes[idx] = 'a' + rune(stream[idx]%26)
+		}
+	}
+	return string(runes)
+}
+
+// keyStream returns n pseudo-random bytes derived from the keyed HMAC of the value
+func (p *Policy) keyStream(value []byte, n int) []byte {
+	var stream []byte
+	for counter := uint32(0); len(stream) < n; counter++ {
+		mac := hmac.New(sha256.New, p.key)
+		_ = binary.Write(mac, binary.BigEndian, counter)
+		mac.Write(value)
+		stream = mac.Sum(stream)
+	}
+	return stream
+}
+
+// SanitizeRecord applies the policy to the record's JSON document and replaces the record value with the sanitized
+// document, sealed with the pii_sanitized header so it is not sanitized twice when retried or replayed.
+func (p *Policy) SanitizeRecord(record models.Record, doc []byte) (models.Record, []byte, error) {
+	if seal, ok := record.Header(models.HeaderPIISanitized); ok && p.isSealed(doc, seal) {
+		return record, doc, nil
+	}
+
+	sanitized, err := p.Sanitize(doc)
+	if err != nil {
+		return record, nil, err
+	}
+	record.Value = sanitized
+	record = record.WithHeader(models.HeaderContentType, "application/json").
+		WithHeader(models.HeaderPIISanitized, p.seal(sanitized))
+	return record, sanitized, nil
+}
+
+// ScrubRecord masks everything that looks like a card number in a record which could not be sanitized
+func (p *Policy) ScrubRecord(record models.Record) models.Record {
+	record.Value = p.Scrub(record.Value)
+	return record
+}
+
+// seal returns the keyed HMAC of the sanitized document. Without a key only mask and drop are
+// allowed, which are safe to apply twice, so the seal is never trusted.
+func (p *Policy) seal(doc []byte) string {
+	if len(p.key) == 0 {
+		return "unsealed"
+	}
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(models.HeaderPIISanitized))
+	mac.Write(doc)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isSealed reports whether the document was sanitized by this policy, a header set by anyone
+// else does not match as they lack the key
+func (p *Policy) isSealed(doc []byte, seal string) bool {
+	if len(p.key) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(p.seal(doc)), []byte(seal))
+}
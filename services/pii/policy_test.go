@@ -0,0 +1,110 @@
+package pii
+
+import (
+	// Go Internal Packages
+	"encoding/json"
+	"strings"
+	"testing"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+)
+
+func TestPolicySanitize(t *testing.T) {
+	p, err := NewPolicy([]byte("secret"), map[string]string{
+		"card_number":      "mask",
+		"user_id":          "hash",
+		"account":          "tokenize",
+		"ip_address":       "drop",
+		"payer.email":      "mask",
+		"missing":          "drop",
+		"payer.missing.id": "hash",
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+
+	doc := []byte(`{"card_number":"4111 1111 1111 1111","user_id":"u-1","account":"AB-12345678",` +
+		`"ip_address":"10.0.0.1","payer":{"email":"a@b.io"},"amount":10.50}`)
+	sanitized, err := p.Sanitize(doc)
+	if err != nil {
+		t.Fatalf("Sanitize() error = %v", err)
+	}
+
+	var got map[string]any
+	if err = json.Unmarshal(sanitized, &got); err != nil {
+		t.Fatalf("cannot decode %s: %v", sanitized, err)
+	}
+	if got["card_number"] != "**** **** **** 1111" {
+		t.Errorf("card_number = %v, want it masked", got["card_number"])
+	}
+	if hash, _ := got["user_id"].(string); len(hash) != 64 || hash == "u-1" {
+		t.Errorf("user_id = %v, want its HMAC", got["user_id"])
+	}
+	if token, _ := got["account"].(string); len(token) != 11 || token[2] != '-' || !strings.HasSuffix(token, "5678") ||
+		token == "AB-12345678" {
+		t.Errorf("account = %v, want a token of the same shape", got["account"])
+	}
+	if _, ok := got["ip_address"]; ok {
+		t.Error("ip_address was not dropped")
+	}
+	if email := got["payer"].(map[string]any)["email"]; email != "*@b.io" {
+		t.Errorf("payer.email = %v, want it masked", email)
+	}
+	if !strings.Contains(string(sanitized), `"amount":10.50`) {
+		t.Errorf("amount changed in %s", sanitized)
+	}
+
+	// the same value always maps to the same hash and token
+	again, _ := p.Sanitize(doc)
+	if string(again) != string(sanitized) {
+		t.Errorf("Sanitize() = %s, then %s, want the same", sanitized, again)
+	}
+
+	if _, err = p.Sanitize([]byte(`[1, 2]`)); !errors.IsPermanent(err) {
+		t.Errorf("Sanitize() error = %v for a non object, want a permanent error", err)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	if _, err := NewPolicy(nil, map[string]string{"card_number": "encrypt"}); err == nil {
+		t.Error("NewPolicy() succeeded with an unknown action")
+	}
+	if _, err := NewPolicy(nil, map[string]string{"card_number": "hash"}); err == nil {
+		t.Error("NewPolicy() succeeded with hash and no key")
+	}
+}
+
+func TestPolicySanitizeRecord(t *testing.T) {
+	p, _ := NewPolicy([]byte("secret"), map[string]string{"user_id": "hash"})
+	record := models.Record{Value: []byte(`{"user_id":"u-1"}`)}
+
+	sanitized, doc, err := p.SanitizeRecord(record, record.Value)
+	if err != nil {
+		t.Fatalf("SanitizeRecord() error = %v", err)
+	}
+	if string(sanitized.Value) != string(doc) || strings.Contains(string(doc), "u-1") {
+		t.Fatalf("SanitizeRecord() value = %s, want the sanitized document", sanitized.Value)
+	}
+
+	// a replayed record is not hashed twice
+	replayed, again, _ := p.SanitizeRecord(sanitized, sanitized.Value)
+	if string(again) != string(doc) || string(replayed.Value) != string(doc) {
+		t.Errorf("replayed record sanitized again to %s, want %s", again, doc)
+	}
+
+	// a seal set by the producer is not trusted
+	forged := record.WithHeader(models.HeaderPIISanitized, "true")
+	if _, doc, _ = p.SanitizeRecord(forged, forged.Value); strings.Contains(string(doc), "u-1") {
+		t.Errorf("forged record was not sanitized, got %s", doc)
+	}
+}
+
+func TestPolicyScrub(t *testing.T) {
+	p, _ := NewPolicy(nil, nil)
+	got := string(p.Scrub([]byte(`{"card":"4111-1111-1111-1111", "order": 42}`)))
+	if want := `{"card":"****-****-****-1111", "order": 42}`; got != want {
+		t.Errorf("Scrub() = %s, want %s", got, want)
+	}
+}
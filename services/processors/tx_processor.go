@@ -2,11 +2,14 @@ package processors
 
 import (
 	// Go Internal Packages
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	// Local Packages
+	errors "tx-stream/errors"
 	models "tx-stream/models"
 
 	// External Packages
@@ -14,50 +17,214 @@ import (
 )
 
 type TxRepository interface {
-	InsertTransactions(ctx context.Context, txs []interface{}) error
-	InsertTransaction(ctx context.Context, tx models.MongoTransaction) error
+	UpsertTransactions(ctx context.Context, txs []models.MongoTransaction) (models.WriteResult, error)
+	UpsertTransaction(ctx context.Context, tx models.MongoTransaction) (models.WriteResult, error)
+	UpsertTransactionsWithOffset(ctx context.Context, txs []models.MongoTransaction, offset models.PartitionOffset) (models.WriteResult, error)
+}
+
+type TxValidator interface {
+	Validate(payload []byte, tx models.Transaction) error
+}
+
+// TxDeserializer converts a record value into the JSON document of a transaction
+type TxDeserializer interface {
+	Deserialize(ctx context.Context, record models.Record) ([]byte, error)
+}
+
+// TxSanitizer removes the PII from a record before it is validated, stored, logged or dead-lettered
+type TxSanitizer interface {
+	// SanitizeRecord returns the record with its value replaced by the sanitized JSON document, and the document
+	SanitizeRecord(record models.Record, doc []byte) (models.Record, []byte, error)
+	// ScrubRecord masks what looks like PII in a record which could not be decoded
+	ScrubRecord(record models.Record) models.Record
 }
 
 type TxProcessor struct {
-	Logger *zap.Logger
-	TxRepo TxRepository
+	Logger       *zap.Logger
+	TxRepo       TxRepository
+	Validator    TxValidator
+	Deserializer TxDeserializer
+	Sanitizer    TxSanitizer
+	Transform    models.TransformOptions
 }
 
-func NewTxProcessor(logger *zap.Logger, txRepo TxRepository) *TxProcessor {
-	return &TxProcessor{TxRepo: txRepo, Logger: logger}
+// NewTxProcessor creates a new transaction processor. Validation is skipped when validator is nil, record
+// values are expected to be JSON when deserializer is nil and are kept as is when sanitizer is nil.
+func NewTxProcessor(logger *zap.Logger, txRepo TxRepository, validator TxValidator, deserializer TxDeserializer,
+	sanitizer TxSanitizer, transform models.TransformOptions) *TxProcessor {
+	return &TxProcessor{
+		TxRepo:       txRepo,
+		Logger:       logger,
+		Validator:    validator,
+		Deserializer: deserializer,
+		Sanitizer:    sanitizer,
+		Transform:    transform,
+	}
+}
+
+// ProcessRecords processes the records and returns the outcome of each record, in the same order
+func (p *TxProcessor) ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult {
+	results, txs, origins := p.transform(ctx, records)
+
+	result, err := p.TxRepo.UpsertTransactions(ctx, txs)
+	p.applyWriteResult(results, origins, result, err)
+	return results
 }
 
-func (p *TxProcessor) ProcessRecords(ctx context.Context, records []models.Record) error {
-	var txs []interface{}
-	for _, record := range records {
-		var tx models.Transaction
-		err := json.Unmarshal(record.Value, &tx)
+// ProcessRecordsWithOffset processes the records and atomically stores the offset to resume from, which is
+// never past a record that failed to transform as it is yet to be retried or dead-lettered. If any record
+// is rejected nothing is stored, so the remaining records are reported as retryable.
+func (p *TxProcessor) ProcessRecordsWithOffset(ctx context.Context, records []models.Record, offset models.PartitionOffset) []models.RecordResult {
+	results, txs, origins := p.transform(ctx, records)
+	for idx, result := range results {
+		if result.Outcome != models.Succeeded {
+			offset.Offset = min(offset.Offset, records[idx].Offset)
+			break
+		}
+	}
+
+	result, err := p.TxRepo.UpsertTransactionsWithOffset(ctx, txs, offset)
+	p.applyWriteResult(results, origins, result, err)
+	return results
+}
+
+func (p *TxProcessor) ProcessRecord(ctx context.Context, record models.Record) models.RecordResult {
+	return p.ProcessRecords(ctx, []models.Record{record})[0]
+}
+
+// transform decodes and validates the records into transactions, records which cannot be decoded or fail
+// validation are marked as permanent failures, while failures to resolve their schema are retryable. The
+// records are sanitized first, so the results only ever carry the sanitized records. origins maps the
+// index of each transaction back to the index of the record it was decoded from.
+func (p *TxProcessor) transform(ctx context.Context, records []models.Record) ([]models.RecordResult, []models.MongoTransaction, []int) {
+	results := make([]models.RecordResult, len(records))
+	txs := make([]models.MongoTransaction, 0, len(records))
+	origins := make([]int, 0, len(records))
+	ingestedAt := time.Now().UTC()
+
+	for idx, record := range records {
+		results[idx] = models.RecordResult{Record: record, Outcome: models.Succeeded}
+
+		traceID, _ := record.Header(models.HeaderTraceID)
+
+		payload, err := p.deserialize(ctx, record)
 		if err != nil {
-			p.Logger.Error("failed to unmarshal transaction", zap.Error(err))
+			p.Logger.Warn("failed to deserialize transaction", zap.String("trace_id", traceID), zap.Error(err))
+			results[idx].Record = p.scrub(record)
+			results[idx].Outcome = models.RetryableFailure
+			if errors.IsPermanent(err) {
+				results[idx].Outcome = models.PermanentFailure
+			}
+			results[idx].Err = err
 			continue
 		}
-		txs = append(txs, tx.Transform())
-	}
 
-	err := p.TxRepo.InsertTransactions(ctx, txs)
-	if err != nil {
-		return fmt.Errorf("failed to insert transactions: %v", err)
+		if p.Sanitizer != nil {
+			if results[idx].Record, payload, err = p.Sanitizer.SanitizeRecord(record, payload); err != nil {
+				p.Logger.Warn("failed to sanitize transaction", zap.String("trace_id", traceID), zap.Error(err))
+				results[idx].Record = p.scrub(record)
+				results[idx].Outcome = models.PermanentFailure
+				results[idx].Err = err
+				continue
+			}
+		}
+
+		tx, doc, err := decode(payload)
+		if err != nil {
+			p.Logger.Warn("failed to unmarshal transaction", zap.String("trace_id", traceID), zap.Error(err))
+			results[idx].Outcome = models.PermanentFailure
+			results[idx].Err = errors.E(errors.Invalid, "failed to unmarshal transaction", err)
+			continue
+		}
+
+		if p.Validator != nil {
+			if err = p.Validator.Validate(payload, tx); err != nil {
+				p.Logger.Warn("transaction failed validation", zap.String("transaction_id", tx.TxID),
+					zap.String("trace_id", traceID), zap.Error(err))
+				results[idx].Outcome = models.PermanentFailure
+				results[idx].Err = errors.E(errors.Invalid, "transaction failed validation", err)
+				continue
+			}
+		}
+
+		mongoTx, err := tx.Transform(doc, p.Transform)
+		if err != nil {
+			p.Logger.Warn("failed to transform transaction", zap.String("transaction_id", tx.TxID),
+				zap.String("trace_id", traceID), zap.Error(err))
+			results[idx].Outcome = models.PermanentFailure
+			results[idx].Err = errors.E(errors.Invalid, "failed to transform transaction", err)
+			continue
+		}
+		mongoTx.Source = models.SourceOf(record)
+		mongoTx.IngestedAt = ingestedAt
+		txs = append(txs, mongoTx)
+		origins = append(origins, idx)
 	}
-	return nil
+	return results, txs, origins
 }
 
-func (p *TxProcessor) ProcessRecord(ctx context.Context, record models.Record) error {
+// decode unmarshals the transaction along with its document, keeping the numbers of the document exact
+func decode(payload []byte) (models.Transaction, map[string]any, error) {
 	var tx models.Transaction
+	if err := json.Unmarshal(payload, &tx); err != nil {
+		return tx, nil, err
+	}
+
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	return tx, doc, decoder.Decode(&doc)
+}
 
-	err := json.Unmarshal(record.Value, &tx)
-	if err != nil {
-		p.Logger.Error("failed to unmarshal transaction", zap.Error(err))
-		return nil
+// deserialize returns the JSON document of the record's transaction
+func (p *TxProcessor) deserialize(ctx context.Context, record models.Record) ([]byte, error) {
+	if p.Deserializer == nil {
+		return record.Value, nil
 	}
+	return p.Deserializer.Deserialize(ctx, record)
+}
 
-	err = p.TxRepo.InsertTransaction(ctx, tx.Transform())
-	if err != nil {
-		return fmt.Errorf("failed to insert transaction: %v", err)
+// scrub masks what looks like PII in a record which could not be sanitized
+func (p *TxProcessor) scrub(record models.Record) models.Record {
+	if p.Sanitizer == nil {
+		return record
 	}
-	return nil
+	return p.Sanitizer.ScrubRecord(record)
+}
+
+// applyWriteResult maps the result of writing the transactions back to the records they came from
+func (p *TxProcessor) applyWriteResult(results []models.RecordResult, origins []int, result models.WriteResult, err error) {
+	if err != nil && len(result.Failed) == 0 {
+		err = fmt.Errorf("failed to upsert transactions: %w", err)
+		outcome := models.RetryableFailure
+		if errors.IsPermanent(err) {
+			outcome = models.PermanentFailure
+		}
+		for _, idx := range origins {
+			results[idx].Outcome = outcome
+			results[idx].Err = err
+		}
+		return
+	}
+
+	for txIdx, idx := range origins {
+		if writeErr, ok := result.Failed[txIdx]; ok {
+			results[idx].Outcome = models.RetryableFailure
+			if errors.IsPermanent(writeErr) {
+				results[idx].Outcome = models.PermanentFailure
+			}
+			results[idx].Err = fmt.Errorf("failed to upsert transaction: %w", writeErr)
+		} else if err != nil {
+			// the batch was rolled back because of the failed transactions, the rest can be retried
+			results[idx].Outcome = models.RetryableFailure
+			results[idx].Err = err
+		}
+	}
+
+	p.Logger.Debug("transactions written",
+		zap.Int("inserted", result.Inserted),
+		zap.Int("updated", result.Updated),
+		zap.Int("skipped", result.Skipped),
+		zap.Int("failed", len(result.Failed)),
+	)
 }
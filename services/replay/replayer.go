@@ -0,0 +1,151 @@
+package replay
+
+import (
+	// Go Internal Packages
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	// Local Packages
+	models "tx-stream/models"
+
+	// External Packages
+	"go.uber.org/zap"
+)
+
+// DeadLetterSource reads the dead letters back from a dead-letter queue backend
+type DeadLetterSource interface {
+	// Next returns the next batch of up to count entries, an empty batch once the queue is exhausted
+	Next(ctx context.Context, count int) ([]models.DeadLetterEntry, error)
+	// Ack removes or acknowledges the entries, so they are not replayed again
+	Ack(ctx context.Context, entries []models.DeadLetterEntry) error
+}
+
+type TxProcessor interface {
+	ProcessRecords(ctx context.Context, records []models.Record) []models.RecordResult
+}
+
+// Filter selects the dead letters to replay, zero valued fields match everything
+type Filter struct {
+	KeyPattern string    // Glob pattern matched against the record key, as in path.Match
+	Since      time.Time // Only dead letters which failed at or after this time
+	Until      time.Time // Only dead letters which failed before this time
+	Reason     string    // Only dead letters whose reason contains this text
+}
+
+// Match reports whether the dead letter is selected by the filter
+func (f Filter) Match(dl models.DeadLetter) bool {
+	if f.KeyPattern != "" {
+		if ok, _ := path.Match(f.KeyPattern, string(dl.Record.Key)); !ok {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && dl.FailedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !dl.FailedAt.Before(f.Until) {
+		return false
+	}
+	if f.Reason != "" && !strings.Contains(dl.Reason, f.Reason) {
+		return false
+	}
+	return true
+}
+
+type Options struct {
+	Filter    Filter
+	DryRun    bool    // Only log the dead letters which would be replayed
+	Rate      float64 // Maximum records replayed per second, unlimited when zero
+	BatchSize int
+}
+
+// Summary counts what happened to the dead letters during a replay
+type Summary struct {
+	Scanned  int
+	Matched  int
+	Replayed int
+	Failed   int
+}
+
+type Replayer struct {
+	source    DeadLetterSource
+	processor TxProcessor
+	options   Options
+	logger    *zap.Logger
+}
+
+func NewReplayer(source DeadLetterSource, processor TxProcessor, options Options, logger *zap.Logger) *Replayer {
+	return &Replayer{source: source, processor: processor, options: options, logger: logger}
+}
+
+// Run replays every matching dead letter through the processor until the source is exhausted. Entries are
+// acknowledged only once they are reprocessed successfully, failed entries stay in the dead-letter queue.
+// Entries left out by the filter are never acknowledged, so a later replay without the filter still finds them.
+func (r *Replayer) Run(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	var limiter *time.Ticker
+	if r.options.Rate > 0 {
+		limiter = time.NewTicker(time.Duration(float64(time.Second) / r.options.Rate))
+		defer limiter.Stop()
+	}
+
+	for {
+		entries, err := r.source.Next(ctx, r.options.BatchSize)
+		if err != nil {
+			return summary, err
+		}
+		if len(entries) == 0 {
+			return summary, nil
+		}
+		summary.Scanned += len(entries)
+
+		var matched []models.DeadLetterEntry
+		for _, entry := range entries {
+			if r.options.Filter.Match(entry.DeadLetter) {
+				matched = append(matched, entry)
+			}
+		}
+		summary.Matched += len(matched)
+
+		if r.options.DryRun {
+			for _, entry := range matched {
+				r.logger.Info("would replay dead letter", zap.String("id", entry.ID),
+					zap.ByteString("key", entry.DeadLetter.Record.Key), zap.String("reason", entry.DeadLetter.Reason),
+					zap.Time("failed_at", entry.DeadLetter.FailedAt))
+			}
+			continue
+		}
+
+		records := make([]models.Record, len(matched))
+		for idx, entry := range matched {
+			if limiter != nil {
+				select {
+				case <-ctx.Done():
+					return summary, ctx.Err()
+				case <-limiter.C:
+				}
+			}
+			records[idx] = entry.DeadLetter.Record
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		var replayed []models.DeadLetterEntry
+		for idx, result := range r.processor.ProcessRecords(ctx, records) {
+			if result.Outcome != models.Succeeded {
+				r.logger.Warn("failed to replay dead letter", zap.String("id", matched[idx].ID), zap.Error(result.Err))
+				summary.Failed++
+				continue
+			}
+			replayed = append(replayed, matched[idx])
+		}
+
+		if err = r.source.Ack(ctx, replayed); err != nil {
+			return summary, err
+		}
+		summary.Replayed += len(replayed)
+	}
+}
@@ -0,0 +1,104 @@
+package validators
+
+import (
+	// Go Internal Packages
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+
+	// External Packages
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// missingPropertyRegex extracts the property names from a jsonschema "missing properties" message
+var missingPropertyRegex = regexp.MustCompile(`'([^']*)'`)
+
+// TxValidator validates incoming transactions against a JSON schema along with the
+// checks which cannot be expressed in a schema, like comparing two fields.
+type TxValidator struct {
+	schema *jsonschema.Schema
+}
+
+// NewTxValidator compiles the JSON schema at the given path
+func NewTxValidator(schemaPath string) (*TxValidator, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+
+	schema, err := compiler.Compile(schemaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema %s: %v", schemaPath, err)
+	}
+	return &TxValidator{schema: schema}, nil
+}
+
+// Validate validates the raw payload against the schema and the decoded transaction against the
+// cross-field rules. Returns errors.ValidationErrors listing every field that failed.
+func (v *TxValidator) Validate(payload []byte, tx models.Transaction) error {
+	ve := errors.ValidationErrs()
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		ve.Add("", err.Error())
+		return ve.Err()
+	}
+
+	var schemaErr *jsonschema.ValidationError
+	if err := v.schema.Validate(doc); errors.As(err, &schemaErr) {
+		addSchemaErrors(ve, schemaErr)
+	} else if err != nil {
+		return err
+	}
+
+	if tx.Discount.Cmp(tx.Amount) > 0 {
+		ve.Add("discount", "cannot be greater than amount")
+	}
+
+	return ve.Err()
+}
+
+// addSchemaErrors adds the leaf errors of the schema validation error as field errors
+func addSchemaErrors(ve *errors.ValidationErrorBuilder, err *jsonschema.ValidationError) {
+	if len(err.Causes) > 0 {
+		for _, cause := range err.Causes {
+			addSchemaErrors(ve, cause)
+		}
+		return
+	}
+
+	if strings.HasSuffix(err.KeywordLocation, "/required") {
+		for _, match := range missingPropertyRegex.FindAllStringSubmatch(err.Message, -1) {
+			ve.Add(fieldPath(err.InstanceLocation, match[1]), "is required")
+		}
+		return
+	}
+	if strings.HasSuffix(err.KeywordLocation, "/enum") {
+		// the message lists every allowed value, which is too long to be useful for large enums
+		ve.Add(fieldPath(err.InstanceLocation, ""), "is not one of the allowed values")
+		return
+	}
+	ve.Add(fieldPath(err.InstanceLocation, ""), err.Message)
+}
+
+// fieldPath converts a JSON pointer like "/source/topic" into a dotted field path like "source.topic"
+func fieldPath(pointer, property string) string {
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	if property != "" {
+		parts = append(parts, property)
+	}
+
+	var fields []string
+	for _, part := range parts {
+		if part != "" {
+			fields = append(fields, part)
+		}
+	}
+	return strings.Join(fields, ".")
+}
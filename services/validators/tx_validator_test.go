@@ -0,0 +1,57 @@
+package validators
+
+import (
+	// Go Internal Packages
+	"encoding/json"
+	"testing"
+
+	// Local Packages
+	errors "tx-stream/errors"
+	models "tx-stream/models"
+)
+
+func TestTxValidatorTimestamp(t *testing.T) {
+	v, err := NewTxValidator("../../schemas/transaction.schema.json")
+	if err != nil {
+		t.Fatalf("NewTxValidator() error = %v", err)
+	}
+
+	tests := []struct {
+		timestamp string
+		valid     bool
+	}{
+		{timestamp: "2025-01-01T00:00:00Z", valid: true},
+		{timestamp: "2025-01-01T05:30:00.123+05:30", valid: true},
+		// layouts are checked when the transaction is transformed, so they can be configured
+		{timestamp: "2025-01-01 00:00:00", valid: true},
+		{timestamp: "01/01/2025", valid: true},
+		{timestamp: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timestamp, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]any{
+				"transaction_id":   "tx-1",
+				"user_id":          "u-1",
+				"amount":           10.5,
+				"currency":         "INR",
+				"transaction_type": "debit",
+				"status":           "completed",
+				"timestamp":        tt.timestamp,
+				"payment_method":   "card",
+			})
+
+			err := v.Validate(payload, models.Transaction{})
+			if tt.valid {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want none", err)
+				}
+				return
+			}
+			var ve errors.ValidationErrors
+			if !errors.As(err, &ve) || len(ve) != 1 || ve[0].Field != "timestamp" {
+				t.Errorf("Validate() error = %v, want a timestamp error", ve)
+			}
+		})
+	}
+}